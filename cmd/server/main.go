@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -39,6 +40,27 @@ func main() {
 
 	// Downloader
 	mgr := downloader.NewManager(st, cfg.dataDir, cfg.workerCount)
+	mgr.SetSegments(cfg.segments)
+	if cfg.quarantineDir != "" {
+		mgr.SetQuarantineDir(cfg.quarantineDir)
+	}
+	mgr.SetRetryPolicy(cfg.retry)
+	mgr.SetBreakerPolicy(cfg.breaker)
+	mgr.SetQueueLimit(cfg.queueLimit)
+	mgr.SetGlobalRateLimit(cfg.rateLimit)
+	hostOverrides, err := downloader.ParseHostLimits(cfg.hostLimits)
+	if err != nil {
+		log.Fatalf("invalid host limits: %v", err)
+	}
+	mgr.SetHostLimits(cfg.hostLimit, hostOverrides)
+	layout, err := downloader.ParseLayout(cfg.layout)
+	if err != nil {
+		log.Fatalf("invalid layout: %v", err)
+	}
+	mgr.SetLayout(layout)
+	if err := mgr.SetWebhookOutbox(cfg.stateDir + "/webhooks.json"); err != nil {
+		log.Fatalf("failed to load webhook outbox: %v", err)
+	}
 	if err := mgr.RestoreFromStorage(); err != nil {
 		log.Fatalf("failed to restore tasks: %v", err)
 	}
@@ -53,6 +75,10 @@ func main() {
 		WriteTimeout:      60 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	// Event streams only end with the client; end them on shutdown too.
+	baseCtx, stopStreams := context.WithCancel(context.Background())
+	srv.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	srv.RegisterOnShutdown(stopStreams)
 
 	// Start server
 	go func() {
@@ -88,38 +114,117 @@ func main() {
 }
 
 type config struct {
-	dataDir     string
-	stateDir    string
-	addr        string
-	workerCount int
+	dataDir       string
+	stateDir      string
+	quarantineDir string
+	layout        string // see downloader.ParseLayout
+	addr          string
+	workerCount   int
+	segments      int
+	retry         downloader.RetryPolicy
+	breaker       downloader.BreakerPolicy
+	queueLimit    int
+	rateLimit     int64
+	hostLimit     downloader.HostLimit
+	hostLimits    string // per-domain overrides, see downloader.ParseHostLimits
 }
 
 const (
 	envDataDir     = "DOWNLOADER_DATA_DIR"
 	envStateDir    = "DOWNLOADER_STATE_DIR"
+	envQuarantine  = "DOWNLOADER_QUARANTINE_DIR"
+	envLayout      = "DOWNLOADER_LAYOUT"
 	envAddr        = "DOWNLOADER_ADDR"
 	envWorkerCount = "DOWNLOADER_WORKERS"
+	envSegments    = "DOWNLOADER_SEGMENTS"
+	envRetryMax    = "DOWNLOADER_RETRY_ATTEMPTS"
+	envRetryBase   = "DOWNLOADER_RETRY_BASE"
+	envRetryCap    = "DOWNLOADER_RETRY_MAX_DELAY"
+	envRetryJitter = "DOWNLOADER_RETRY_JITTER"
+	envQueueLimit  = "DOWNLOADER_QUEUE_LIMIT"
+	envRateLimit   = "DOWNLOADER_RATE_LIMIT"
+	envBreakerMax  = "DOWNLOADER_BREAKER_THRESHOLD"
+	envBreakerWin  = "DOWNLOADER_BREAKER_WINDOW"
+	envBreakerCool = "DOWNLOADER_BREAKER_COOLDOWN"
+	envHostConns   = "DOWNLOADER_HOST_CONNS"
+	envHostDelay   = "DOWNLOADER_HOST_DELAY"
+	envHostLimits  = "DOWNLOADER_HOST_LIMITS"
 )
 
 func loadConfig() config {
+	retry := downloader.DefaultRetryPolicy()
+	breaker := downloader.DefaultBreakerPolicy()
 	cfg := config{
-		dataDir:     envOrDefault(envDataDir, "data"),
-		stateDir:    envOrDefault(envStateDir, "state"),
-		addr:        envOrDefault(envAddr, ":8080"),
-		workerCount: envOrInt(envWorkerCount, 4),
+		dataDir:       envOrDefault(envDataDir, "data"),
+		stateDir:      envOrDefault(envStateDir, "state"),
+		quarantineDir: envOrDefault(envQuarantine, ""),
+		layout:        envOrDefault(envLayout, downloader.DefaultLayout),
+		addr:          envOrDefault(envAddr, ":8080"),
+		workerCount:   envOrInt(envWorkerCount, 4),
+		segments:      envOrInt(envSegments, 1),
+		retry: downloader.RetryPolicy{
+			MaxAttempts: envOrInt(envRetryMax, retry.MaxAttempts),
+			BaseDelay:   envOrDuration(envRetryBase, retry.BaseDelay),
+			MaxDelay:    envOrDuration(envRetryCap, retry.MaxDelay),
+			Jitter:      envOrFloat(envRetryJitter, retry.Jitter),
+		},
+		breaker: downloader.BreakerPolicy{
+			Threshold:   envOrInt(envBreakerMax, breaker.Threshold),
+			Window:      envOrInt(envBreakerWin, breaker.Window),
+			Cooldown:    envOrDuration(envBreakerCool, breaker.Cooldown),
+			MaxCooldown: breaker.MaxCooldown,
+		},
+		queueLimit: envOrInt(envQueueLimit, 10000),
+		rateLimit:  int64(envOrInt(envRateLimit, 0)),
+		hostLimit: downloader.HostLimit{
+			MaxConns: envOrInt(envHostConns, 0),
+			Delay:    envOrDuration(envHostDelay, 0),
+		},
+		hostLimits: envOrDefault(envHostLimits, ""),
 	}
 
 	dataDirFlag := flag.String("data-dir", cfg.dataDir, "directory for downloaded files")
 	stateDirFlag := flag.String("state-dir", cfg.stateDir, "directory for task state storage")
+	quarantineFlag := flag.String("quarantine-dir", cfg.quarantineDir, "where files failing checksum verification are moved (default <data-dir>/.quarantine)")
+	layoutFlag := flag.String("layout", cfg.layout, "where files go inside data-dir, e.g. {task_id}/{name} or {host}/{date}/{name}")
 	addrFlag := flag.String("addr", cfg.addr, "HTTP listen address")
 	workersFlag := flag.Int("workers", cfg.workerCount, "number of download workers")
+	segmentsFlag := flag.Int("segments", cfg.segments, "parallel byte ranges per file when the server supports Range")
+	queueLimitFlag := flag.Int("queue-limit", cfg.queueLimit, "max queued files before new tasks are rejected, 0 for no limit")
+	rateLimitFlag := flag.Int64("rate-limit", cfg.rateLimit, "global download bandwidth in bytes per second, 0 for unlimited")
+	breakerMaxFlag := flag.Int("breaker-threshold", cfg.breaker.Threshold, "failures within the window that open a host's circuit breaker, 0 to disable")
+	breakerWinFlag := flag.Int("breaker-window", cfg.breaker.Window, "number of recent downloads per host the breaker looks at")
+	breakerCoolFlag := flag.Duration("breaker-cooldown", cfg.breaker.Cooldown, "how long an open breaker defers a host, doubled on every reopening")
+	hostConnsFlag := flag.Int("host-conns", cfg.hostLimit.MaxConns, "max files downloaded from one host at a time, 0 for no limit")
+	hostDelayFlag := flag.Duration("host-delay", cfg.hostLimit.Delay, "minimum delay between downloads starting on the same host")
+	hostLimitsFlag := flag.String("host-limits", cfg.hostLimits, "per-domain overrides, e.g. example.com=2/500ms,cdn.example.org=8")
+	retryMaxFlag := flag.Int("retry-attempts", cfg.retry.MaxAttempts, "download attempts per file before giving up")
+	retryBaseFlag := flag.Duration("retry-base", cfg.retry.BaseDelay, "initial retry backoff")
+	retryCapFlag := flag.Duration("retry-max-delay", cfg.retry.MaxDelay, "upper bound for retry backoff")
+	retryJitterFlag := flag.Float64("retry-jitter", cfg.retry.Jitter, "random spread of retry backoff, fraction of the delay")
 
 	flag.Parse()
 
 	cfg.dataDir = *dataDirFlag
 	cfg.stateDir = *stateDirFlag
+	cfg.quarantineDir = *quarantineFlag
+	cfg.layout = *layoutFlag
 	cfg.addr = *addrFlag
 	cfg.workerCount = *workersFlag
+	cfg.segments = *segmentsFlag
+	cfg.queueLimit = *queueLimitFlag
+	cfg.rateLimit = *rateLimitFlag
+	cfg.breaker.Threshold = *breakerMaxFlag
+	cfg.breaker.Window = *breakerWinFlag
+	cfg.breaker.Cooldown = *breakerCoolFlag
+	cfg.hostLimit = downloader.HostLimit{MaxConns: *hostConnsFlag, Delay: *hostDelayFlag}
+	cfg.hostLimits = *hostLimitsFlag
+	cfg.retry = downloader.RetryPolicy{
+		MaxAttempts: *retryMaxFlag,
+		BaseDelay:   *retryBaseFlag,
+		MaxDelay:    *retryCapFlag,
+		Jitter:      *retryJitterFlag,
+	}
 
 	return cfg
 }
@@ -142,3 +247,27 @@ func envOrInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func envOrDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf("invalid value for %s: %v", key, err)
+			return fallback
+		}
+		return parsed
+	}
+	return fallback
+}
+
+func envOrFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Printf("invalid value for %s: %v", key, err)
+			return fallback
+		}
+		return parsed
+	}
+	return fallback
+}
@@ -1,13 +1,26 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"test-task-30-09-2025/internal/downloader"
 	"test-task-30-09-2025/internal/storage"
+	"test-task-30-09-2025/internal/ws"
 )
 
 type Handler struct {
@@ -30,35 +43,135 @@ func (h *Handler) routes() {
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	// Create task
-	h.mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			h.createTask(w, r)
-		case http.MethodGet:
-			h.listTasks(w, r)
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-		}
-	})
+	h.mux.HandleFunc("POST /tasks", h.createTask)
+	// Paged, filtered listing, see taskQuery
+	h.mux.HandleFunc("GET /tasks", h.listTasks)
+	h.mux.HandleFunc("GET /tasks/{id}", h.getTask)
+	// Server-Sent Events with status changes and progress of one task
+	h.mux.HandleFunc("GET /tasks/{id}/events", h.taskEvents)
+	// WebSocket with the events of all tasks, see eventFilter
+	h.mux.HandleFunc("GET /ws", h.eventsSocket)
 
-	// Get task by id
-	h.mux.HandleFunc("/tasks/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
+	// Cancel task, ?purge=true also removes partially downloaded files
+	h.mux.HandleFunc("DELETE /tasks/{id}", h.cancelTask)
+	h.mux.HandleFunc("POST /tasks/{id}/cancel", h.cancelTask)
+
+	h.mux.HandleFunc("POST /tasks/{id}/pause", h.pauseTask)
+	h.mux.HandleFunc("POST /tasks/{id}/resume", h.resumeTask)
+
+	h.mux.HandleFunc("PUT /tasks/{id}/priority", h.setPriority)
+
+	// Finished files, with Range and conditional requests
+	h.mux.HandleFunc("GET /tasks/{id}/files/{index}", h.serveFileByIndex)
+	h.mux.HandleFunc("GET /tasks/{id}/files/by-name/{name...}", h.serveFileByName)
+	// All files of a task in one streamed archive, ?format=zip|tar.gz&partial=true
+	h.mux.HandleFunc("GET /tasks/{id}/archive", h.serveArchive)
+
+	h.mux.HandleFunc("GET /admin/queue", h.queueStats)
+	h.mux.HandleFunc("GET /admin/hosts", h.hostStats)
+
+	// Bandwidth limits in bytes per second, applied to running downloads
+	h.mux.HandleFunc("GET /admin/limits", h.getLimits)
+	h.mux.HandleFunc("PUT /admin/limits", h.setGlobalLimit)
+	h.mux.HandleFunc("PUT /admin/limits/tasks/{id}", h.setTaskLimit)
+}
+
+// queueFullRetryAfter is what clients are told to wait when the queue is full.
+const queueFullRetryAfter = "10"
+
+type createTaskRequest struct {
+	URLs      []json.RawMessage `json:"urls"` // see parseURLItem
+	Priority  priority          `json:"priority"`
+	RateLimit int64             `json:"rate_limit"` // bytes per second
+	Layout    string            `json:"layout"`     // e.g. "{task_id}/{name}", see downloader.ParseLayout
+	// Notified with a POST when the task finishes; the body is signed with
+	// the secret (X-Signature-256) if one is given.
+	CallbackURL    string `json:"callback_url"`
+	CallbackSecret string `json:"callback_secret"`
+}
+
+// urlItem is the object form of an element of urls. A plain string is
+// shorthand for {"url": "..."}.
+type urlItem struct {
+	URL      string            `json:"url"`
+	FileName string            `json:"file_name"`
+	Subdir   string            `json:"subdir"`
+	Headers  map[string]string `json:"headers"`
+	Checksum string            `json:"checksum"` // "sha256:<hex>", sha1 and md5 work too
+	Size     int64             `json:"size"`
+	Mirrors  []string          `json:"mirrors"`
+}
+
+// itemError points at the element of urls (and its field) that failed
+// validation.
+type itemError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type validationResponse struct {
+	Error   string      `json:"error"`
+	Details []itemError `json:"details"`
+}
+
+// parseURLItem decodes one element of urls into a validated spec. Every
+// problem found is reported as an itemError for index i.
+func parseURLItem(i int, raw json.RawMessage) (downloader.PartSpec, []itemError) {
+	var item urlItem
+	if err := json.Unmarshal(raw, &item.URL); err != nil {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&item); err != nil {
+			return downloader.PartSpec{}, []itemError{{Index: i, Message: "must be a URL string or an object: " + err.Error()}}
 		}
-		id := strings.TrimPrefix(r.URL.Path, "/tasks/")
-		if id == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	}
+	spec := downloader.PartSpec{
+		URL:      item.URL,
+		FileName: item.FileName,
+		Subdir:   item.Subdir,
+		Headers:  item.Headers,
+		Checksum: item.Checksum,
+		Size:     item.Size,
+		Mirrors:  item.Mirrors,
+	}
+	var errs []itemError
+	if err := spec.Normalize(); err != nil {
+		list := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			list = joined.Unwrap()
 		}
-		h.getTask(w, r, id)
-	})
+		for _, e := range list {
+			var fe *downloader.FieldError
+			if errors.As(e, &fe) {
+				errs = append(errs, itemError{Index: i, Field: fe.Field, Message: fe.Message})
+			} else {
+				errs = append(errs, itemError{Index: i, Message: e.Error()})
+			}
+		}
+	}
+	return spec, errs
 }
 
-type createTaskRequest struct {
-	URLs []string `json:"urls"`
+// priority accepts both "low"/"normal"/"high" and a plain integer.
+type priority int
+
+func (p *priority) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = priority(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("priority must be a string or an integer")
+	}
+	v, err := downloader.ParsePriority(s)
+	if err != nil {
+		return err
+	}
+	*p = priority(v)
+	return nil
 }
 
 func (h *Handler) createTask(w http.ResponseWriter, r *http.Request) {
@@ -71,30 +184,561 @@ func (h *Handler) createTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "urls required", http.StatusBadRequest)
 		return
 	}
+	if req.RateLimit < 0 {
+		http.Error(w, "rate_limit must not be negative", http.StatusBadRequest)
+		return
+	}
+	if _, err := downloader.ParseLayout(req.Layout); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.CallbackURL != "" {
+		if err := downloader.CheckCallbackURL(req.CallbackURL); err != nil {
+			http.Error(w, "callback_url: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if req.CallbackSecret != "" {
+		http.Error(w, "callback_secret requires callback_url", http.StatusBadRequest)
+		return
+	}
+	specs := make([]downloader.PartSpec, len(req.URLs))
+	var invalid []itemError
+	for i, raw := range req.URLs {
+		spec, errs := parseURLItem(i, raw)
+		specs[i] = spec
+		invalid = append(invalid, errs...)
+	}
+	if len(invalid) > 0 {
+		writeJSON(w, http.StatusBadRequest, validationResponse{Error: "invalid urls", Details: invalid})
+		return
+	}
 
-	task, err := h.manager.CreateTask(r.Context(), req.URLs)
+	task, err := h.manager.CreateTask(r.Context(), specs, downloader.TaskOptions{
+		Priority:       int(req.Priority),
+		RateLimit:      req.RateLimit,
+		Layout:         req.Layout,
+		Callback:       req.CallbackURL,
+		CallbackSecret: req.CallbackSecret,
+	})
+	if errors.Is(err, downloader.ErrQueueFull) {
+		w.Header().Set("Retry-After", queueFullRetryAfter)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, downloader.ErrTaskTooLarge) {
+		// Retrying cannot help, the task has to be split.
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		log.Printf("create task error: %v", err)
 		http.Error(w, "failed to create task", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	_ = json.NewEncoder(w).Encode(task)
+	writeJSON(w, http.StatusAccepted, publicTask(task))
 }
 
-func (h *Handler) getTask(w http.ResponseWriter, _ *http.Request, id string) {
-	task, ok := h.storage.Get(id)
+func (h *Handler) getTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := h.storage.Get(r.PathValue("id"))
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(task)
+	writeJSON(w, http.StatusOK, publicTask(task))
+}
+
+// sseHeartbeat keeps idle event streams from being cut by proxies.
+const sseHeartbeat = 15 * time.Second
+
+// taskEvents streams the events of a task as Server-Sent Events. A client
+// reconnecting with Last-Event-ID gets the state transitions it missed; a
+// new client, or one that missed more than is kept, starts with a snapshot
+// event carrying the whole task.
+func (h *Handler) taskEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := h.storage.Get(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	since, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub := h.manager.Subscribe(since, func(e downloader.Event) bool { return e.TaskID == id })
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if since == 0 || sub.Missed {
+		task, _ := h.storage.Get(id)
+		writeSSE(w, sub.LastID, "snapshot", publicTask(task))
+	}
+	for _, e := range sub.Backlog {
+		writeSSE(w, e.ID, e.Type, e)
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Fell behind; the client reconnects and catches up.
+				return
+			}
+			writeSSE(w, e.ID, e.Type, e)
+		case <-heartbeat.C:
+			_, _ = w.Write([]byte(": ping\n\n"))
+		case <-r.Context().Done():
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, id uint64, event string, v any) {
+	data, _ := json.Marshal(v)
+	_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+}
+
+// eventFilter narrows the /ws firehose. Empty lists match everything,
+// except that progress events are only sent when asked for in types.
+type eventFilter struct {
+	TaskIDs  []string `json:"task_ids"`
+	Statuses []string `json:"statuses"`
+	Types    []string `json:"types"`
+}
+
+func (f *eventFilter) match(e downloader.Event) bool {
+	if len(f.Types) == 0 {
+		if e.Type == downloader.EventProgress {
+			return false
+		}
+	} else if !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	if len(f.TaskIDs) > 0 && !slices.Contains(f.TaskIDs, e.TaskID) {
+		return false
+	}
+	return len(f.Statuses) == 0 || slices.Contains(f.Statuses, e.Status)
+}
+
+// queryList reads a query parameter that is repeatable or comma separated.
+func queryList(q url.Values, key string) []string {
+	var out []string
+	for _, v := range q[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+// filterFromQuery reads ?task_id=, ?status= and ?type=.
+func filterFromQuery(q url.Values) *eventFilter {
+	return &eventFilter{
+		TaskIDs:  queryList(q, "task_id"),
+		Statuses: queryList(q, "status"),
+		Types:    queryList(q, "type"),
+	}
+}
+
+const (
+	// wsPingInterval keeps idle sockets alive through proxies.
+	wsPingInterval = 30 * time.Second
+	// wsWriteTimeout drops clients that stopped reading.
+	wsWriteTimeout = 10 * time.Second
+)
+
+// eventsSocket pushes the events of all tasks over a WebSocket. The initial
+// filter comes from the query; a text message with an eventFilter object
+// replaces it at any time. ?last_event_id= replays missed transitions like
+// Last-Event-ID does for SSE, or sends a "missed" message when they are no
+// longer kept.
+func (h *Handler) eventsSocket(w http.ResponseWriter, r *http.Request) {
+	var filter atomic.Pointer[eventFilter]
+	filter.Store(filterFromQuery(r.URL.Query()))
+	since, _ := strconv.ParseUint(r.URL.Query().Get("last_event_id"), 10, 64)
+
+	conn, err := ws.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	sub := h.manager.Subscribe(since, func(e downloader.Event) bool { return filter.Load().match(e) })
+	defer sub.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			f := new(eventFilter)
+			if err := json.Unmarshal(msg, f); err != nil {
+				reply, _ := json.Marshal(map[string]string{"error": "invalid filter: " + err.Error()})
+				_ = conn.WriteText(reply)
+				continue
+			}
+			filter.Store(f)
+		}
+	}()
+
+	send := func(v any) bool {
+		data, _ := json.Marshal(v)
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteText(data) == nil
+	}
+	if since > 0 && sub.Missed {
+		// Some transitions are gone or the ID is from before a restart:
+		// the client has to reload the tasks it follows.
+		if !send(map[string]any{"type": "missed", "id": sub.LastID}) {
+			conn.Close(ws.CloseGoingAway, "")
+			return
+		}
+	}
+	for _, e := range sub.Backlog {
+		if !send(e) {
+			conn.Close(ws.CloseGoingAway, "")
+			return
+		}
+	}
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Fell behind; reconnecting with last_event_id catches up.
+				conn.Close(ws.CloseTryAgainLater, "too slow")
+				return
+			}
+			if !send(e) {
+				conn.Close(ws.CloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if conn.Ping() != nil {
+				conn.Close(ws.CloseGoingAway, "")
+				return
+			}
+		case <-ctx.Done():
+			conn.Close(ws.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var taskStatuses = []string{"pending", "running", "paused", "done", "error", "partial", "cancelled"}
+
+// listTasks returns one page of tasks, newest first unless ?sort=created_at.
+// The cursor of the next page comes in X-Next-Cursor and a Link header;
+// the filters have to be repeated with it.
+func (h *Handler) listTasks(w http.ResponseWriter, r *http.Request) {
+	q, err := taskQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tasks, next := h.storage.Query(q)
+	if next != nil {
+		cursor := next.String()
+		params := r.URL.Query()
+		params.Set("cursor", cursor)
+		w.Header().Set("X-Next-Cursor", cursor)
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, params.Encode()))
+	}
+	for i, t := range tasks {
+		tasks[i] = publicTask(t)
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// taskQuery reads ?status=, ?created_from=, ?created_to=, ?q=, ?sort=,
+// ?limit= and ?cursor=.
+func taskQuery(params url.Values) (storage.Query, error) {
+	q := storage.Query{
+		Statuses: queryList(params, "status"),
+		Search:   strings.TrimSpace(params.Get("q")),
+		Limit:    defaultPageSize,
+	}
+	for _, st := range q.Statuses {
+		if !slices.Contains(taskStatuses, st) {
+			return q, fmt.Errorf("unknown status %q", st)
+		}
+	}
+	var err error
+	if q.CreatedFrom, err = parseTimeParam(params, "created_from"); err != nil {
+		return q, err
+	}
+	if q.CreatedTo, err = parseTimeParam(params, "created_to"); err != nil {
+		return q, err
+	}
+	switch params.Get("sort") {
+	case "", "-created_at":
+		q.Desc = true
+	case "created_at":
+	default:
+		return q, errors.New("sort must be created_at or -created_at")
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+	}
+	if v := params.Get("cursor"); v != "" {
+		c, err := storage.ParseCursor(v)
+		if err != nil {
+			return q, err
+		}
+		if c.Desc != q.Desc {
+			return q, errors.New("cursor belongs to a different sort order")
+		}
+		q.After = &c
+	}
+	return q, nil
+}
+
+// parseTimeParam reads a time given in unix seconds or as RFC 3339.
+func parseTimeParam(params url.Values, key string) (int64, error) {
+	v := params.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be unix seconds or RFC 3339", key)
+	}
+	return t.Unix(), nil
+}
+
+func (h *Handler) cancelTask(w http.ResponseWriter, r *http.Request) {
+	purge, _ := strconv.ParseBool(r.URL.Query().Get("purge"))
+	task, err := h.manager.Cancel(r.PathValue("id"), purge)
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, publicTask(task))
+}
+
+func (h *Handler) pauseTask(w http.ResponseWriter, r *http.Request) {
+	task, err := h.manager.Pause(r.PathValue("id"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, publicTask(task))
+}
+
+func (h *Handler) resumeTask(w http.ResponseWriter, r *http.Request) {
+	task, err := h.manager.Resume(r.PathValue("id"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, publicTask(task))
+}
+
+func (h *Handler) queueStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.manager.QueueStats())
+}
+
+func (h *Handler) hostStats(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, h.manager.HostStats())
+}
+
+func (h *Handler) serveFileByIndex(w http.ResponseWriter, r *http.Request) {
+	idx, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		http.Error(w, "index must be an integer", http.StatusBadRequest)
+		return
+	}
+	h.serveFile(w, r, idx)
+}
+
+func (h *Handler) serveFileByName(w http.ResponseWriter, r *http.Request) {
+	idx, err := h.manager.PartIndex(r.PathValue("id"), r.PathValue("name"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	h.serveFile(w, r, idx)
+}
+
+// serveFile streams a finished file. The stored checksum doubles as a strong
+// ETag, so clients can revalidate with If-None-Match and resume with
+// If-Range; http.ServeContent does the rest.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, idx int) {
+	f, part, err := h.manager.OpenFile(r.PathValue("id"), idx)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "file is missing on disk", http.StatusGone)
+		return
+	}
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("stat %s: %v", part.FileName, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	name := path.Base(part.FileName)
+	if part.Checksum != "" {
+		w.Header().Set("ETag", `"`+part.Checksum+`"`)
+	}
+	if cd := mime.FormatMediaType("attachment", map[string]string{"filename": name}); cd != "" {
+		w.Header().Set("Content-Disposition", cd)
+	}
+	// Big files take longer than the server write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func (h *Handler) serveArchive(w http.ResponseWriter, r *http.Request) {
+	format, err := downloader.ParseArchiveFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partial, _ := strconv.ParseBool(r.URL.Query().Get("partial"))
+	archive, err := h.manager.Archive(r.PathValue("id"), partial)
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	name := archive.Manifest.TaskID + "." + string(format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err := archive.Write(w, format); err != nil {
+		// The status line is long gone; drop the connection so the client
+		// does not take the truncated archive for a complete one.
+		log.Printf("archive %s: %v", archive.Manifest.TaskID, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+type limitsResponse struct {
+	Global int64 `json:"global"`
+}
+
+type limitRequest struct {
+	Global *int64 `json:"global"`
+	Rate   *int64 `json:"rate"`
+}
+
+func (h *Handler) getLimits(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, limitsResponse{Global: h.manager.GlobalRateLimit()})
+}
+
+func (h *Handler) setGlobalLimit(w http.ResponseWriter, r *http.Request) {
+	var req limitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Global == nil || *req.Global < 0 {
+		http.Error(w, `expected {"global": <bytes per second, 0 for unlimited>}`, http.StatusBadRequest)
+		return
+	}
+	h.manager.SetGlobalRateLimit(*req.Global)
+	writeJSON(w, http.StatusOK, limitsResponse{Global: h.manager.GlobalRateLimit()})
+}
+
+func (h *Handler) setTaskLimit(w http.ResponseWriter, r *http.Request) {
+	var req limitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Rate == nil || *req.Rate < 0 {
+		http.Error(w, `expected {"rate": <bytes per second, 0 for unlimited>}`, http.StatusBadRequest)
+		return
+	}
+	task, err := h.manager.SetTaskRateLimit(r.PathValue("id"), *req.Rate)
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, publicTask(task))
+}
+
+type priorityRequest struct {
+	Priority *priority `json:"priority"`
+}
+
+func (h *Handler) setPriority(w http.ResponseWriter, r *http.Request) {
+	var req priorityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Priority == nil {
+		http.Error(w, "priority required", http.StatusBadRequest)
+		return
+	}
+	task, err := h.manager.SetPriority(r.PathValue("id"), int(*req.Priority))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, publicTask(task))
+}
+
+// redactedHeader replaces the values of per-URL request headers in API
+// responses. They often carry credentials and only the downloader needs
+// them; the names stay visible.
+const redactedHeader = "[redacted]"
+
+// publicTask returns t as shown to API clients, copied only when there is
+// something to redact.
+func publicTask(t *storage.Task) *storage.Task {
+	out := t
+	for i, p := range t.Parts {
+		if len(p.Headers) == 0 {
+			continue
+		}
+		if out == t {
+			out = t.Clone()
+		}
+		for name := range out.Parts[i].Headers {
+			out.Parts[i].Headers[name] = redactedHeader
+		}
+	}
+	return out
+}
+
+// writeManagerError maps downloader errors to HTTP statuses.
+func writeManagerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, downloader.ErrTaskNotFound), errors.Is(err, downloader.ErrPartNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, downloader.ErrTaskFinished), errors.Is(err, downloader.ErrTaskNotPaused),
+		errors.Is(err, downloader.ErrPartNotDone):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("task control error: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
 }
 
-func (h *Handler) listTasks(w http.ResponseWriter, _ *http.Request) {
-	tasks := h.storage.List()
+func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(tasks)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
 }
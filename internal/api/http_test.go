@@ -0,0 +1,430 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/downloader"
+	"test-task-30-09-2025/internal/storage"
+)
+
+// newTestHandler serves the API over a manager without workers, so tasks
+// stay as the test puts them.
+func newTestHandler(t *testing.T) (http.Handler, *storage.FileStorage, string) {
+	t.Helper()
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	mgr := downloader.NewManager(st, tmp, 0)
+	t.Cleanup(mgr.Shutdown)
+	return NewHandler(st, mgr).Router(), st, tmp
+}
+
+func serve(h http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreateTaskReportsInvalidItems(t *testing.T) {
+	h, st, _ := newTestHandler(t)
+	body := `{"urls":["https://example.com/ok.bin",{"url":"/relative","file_name":"a/b"},42,{"url":"https://example.com/x","bogus":1}]}`
+	rec := serve(h, http.MethodPost, "/tasks", body, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp validationResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	got := make(map[int][]string)
+	for _, d := range resp.Details {
+		if d.Message == "" {
+			t.Fatalf("detail without a message: %+v", d)
+		}
+		got[d.Index] = append(got[d.Index], d.Field)
+	}
+	if resp.Error != "invalid urls" || len(got) != 3 || len(got[1]) != 2 || got[2][0] != "" || got[3][0] != "" {
+		t.Fatalf("unexpected details %+v", resp)
+	}
+	if n := len(st.List()); n != 0 {
+		t.Fatalf("invalid request must not create a task, have %d", n)
+	}
+}
+
+func TestTaskResponsesRedactHeaders(t *testing.T) {
+	h, st, _ := newTestHandler(t)
+	body := `{"urls":[{"url":"https://example.com/a.bin","headers":{"Authorization":"Bearer s3cret"}}]}`
+	rec := serve(h, http.MethodPost, "/tasks", body, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var task storage.Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &task); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for _, target := range []string{"/tasks/" + task.ID, "/tasks"} {
+		got := serve(h, http.MethodGet, target, "", nil).Body.String()
+		if strings.Contains(got, "s3cret") || !strings.Contains(got, `"Authorization":"`+redactedHeader+`"`) {
+			t.Fatalf("%s: header value not redacted: %s", target, got)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "s3cret") {
+		t.Fatalf("create response leaks the header: %s", rec.Body)
+	}
+	if stored, _ := st.Get(task.ID); stored.Parts[0].Headers["Authorization"] != "Bearer s3cret" {
+		t.Fatalf("the downloader still needs the real value, got %+v", stored.Parts[0].Headers)
+	}
+}
+
+func TestServeFileHonoursETagAndRange(t *testing.T) {
+	h, st, dir := newTestHandler(t)
+	st.Put(&storage.Task{ID: "t1", Status: "running", Parts: []storage.FilePart{
+		{URL: "https://example.com/a.bin", FileName: "docs/a.bin", Status: "done", Checksum: "sha256:abc"},
+		{URL: "https://example.com/b.bin", FileName: "b.bin", Status: "downloading"},
+	}})
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "docs", "a.bin"), []byte("hello world"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	rec := serve(h, http.MethodGet, "/tasks/t1/files/0", "", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("expected the file, got %d: %q", rec.Code, rec.Body)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"sha256:abc"` {
+		t.Fatalf("unexpected ETag %q", etag)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != "attachment; filename=a.bin" {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+
+	rec = serve(h, http.MethodGet, "/tasks/t1/files/by-name/a.bin", "", http.Header{"If-None-Match": {`"sha256:abc"`}})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for a matching ETag, got %d", rec.Code)
+	}
+	rec = serve(h, http.MethodGet, "/tasks/t1/files/0", "", http.Header{
+		"Range":    {"bytes=6-"},
+		"If-Range": {`"sha256:abc"`},
+	})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Fatalf("expected the tail of the file, got %d: %q", rec.Code, rec.Body)
+	}
+	rec = serve(h, http.MethodGet, "/tasks/t1/files/0", "", http.Header{
+		"Range":    {"bytes=6-"},
+		"If-Range": {`"sha256:old"`},
+	})
+	if rec.Code != http.StatusOK || rec.Body.Len() != len("hello world") {
+		t.Fatalf("a stale If-Range must get the whole file, got %d", rec.Code)
+	}
+
+	for target, want := range map[string]int{
+		"/tasks/t1/files/1":             http.StatusConflict,
+		"/tasks/t1/files/by-name/b.bin": http.StatusConflict,
+		"/tasks/t1/files/7":             http.StatusNotFound,
+		"/tasks/t1/files/x":             http.StatusBadRequest,
+		"/tasks/nope/files/0":           http.StatusNotFound,
+	} {
+		if rec := serve(h, http.MethodGet, target, "", nil); rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "docs", "a.bin")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if rec := serve(h, http.MethodGet, "/tasks/t1/files/0", "", nil); rec.Code != http.StatusGone {
+		t.Fatalf("expected 410 for a file removed from disk, got %d", rec.Code)
+	}
+}
+
+func TestListTasksRejectsBadQueries(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+	asc := storage.Cursor{CreatedAt: 1, ID: "t1"}.String()
+	for _, query := range []string{
+		"status=nope",
+		"created_from=yesterday",
+		"created_to=2024-13-01T00:00:00Z",
+		"sort=priority",
+		"limit=0",
+		"limit=1001",
+		"limit=ten",
+		"cursor=%25%25",
+		"cursor=" + asc, // taken with the default newest-first sort
+	} {
+		if rec := serve(h, http.MethodGet, "/tasks?"+query, "", nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if rec := serve(h, http.MethodGet, "/tasks?sort=created_at&cursor="+asc+"&created_from=2024-03-01T00:00:00Z", "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected a valid query to pass, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestListTasksPagesThroughLinkHeader(t *testing.T) {
+	h, st, _ := newTestHandler(t)
+	for i, status := range []string{"done", "error", "done", "running", "done", "partial", "done"} {
+		st.Put(&storage.Task{
+			ID: fmt.Sprintf("t%d", i), CreatedAt: int64(1000 + i), Status: status,
+			Parts: []storage.FilePart{{URL: fmt.Sprintf("https://host%d.example.com/f.bin", i%2)}},
+		})
+	}
+
+	var ids []string
+	target := "/tasks?status=done,error&q=HOST0&limit=2"
+	for pages := 0; target != ""; pages++ {
+		if pages > 5 {
+			t.Fatalf("paging does not end")
+		}
+		rec := serve(h, http.MethodGet, target, "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, rec.Code, rec.Body)
+		}
+		var page []storage.Task
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		for _, task := range page {
+			ids = append(ids, task.ID)
+		}
+		target = ""
+		if link := rec.Header().Get("Link"); link != "" {
+			if !strings.HasPrefix(link, "</tasks?") || !strings.HasSuffix(link, `>; rel="next"`) {
+				t.Fatalf("unexpected Link %q", link)
+			}
+			target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			next, _ := url.Parse(target)
+			if next.Query().Get("cursor") != rec.Header().Get("X-Next-Cursor") || next.Query().Get("q") != "HOST0" {
+				t.Fatalf("Link must carry the cursor and the filters, got %q", link)
+			}
+		}
+	}
+	// Even IDs are on host0; done or error, newest first.
+	if got := strings.Join(ids, ","); got != "t6,t4,t2,t0" {
+		t.Fatalf("unexpected pages %s", got)
+	}
+
+	rec := serve(h, http.MethodGet, "/tasks?status=paused", "", nil)
+	if strings.TrimSpace(rec.Body.String()) != "[]" || rec.Header().Get("Link") != "" {
+		t.Fatalf("expected an empty list without a next page, got %q", rec.Body)
+	}
+}
+
+// wsClient speaks just enough WebSocket to follow /ws.
+type wsClient struct {
+	nc net.Conn
+	br *bufio.Reader
+}
+
+// dialEvents opens /ws with the given query.
+func dialEvents(t *testing.T, srv *httptest.Server, query string) *wsClient {
+	t.Helper()
+	nc, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+	req := "GET /ws?" + query + " HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"
+	if _, err := nc.Write([]byte(req)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	c := &wsClient{nc: nc, br: bufio.NewReader(nc)}
+	resp, err := http.ReadResponse(c.br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed: %v %v", err, resp)
+	}
+	return c
+}
+
+// send writes a masked text frame.
+func (c *wsClient) send(t *testing.T, msg string) {
+	t.Helper()
+	if len(msg) > 125 {
+		t.Fatalf("message too long for the test client")
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(msg))}, mask...)
+	for i := range len(msg) {
+		frame = append(frame, msg[i]^mask[i%4])
+	}
+	if _, err := c.nc.Write(frame); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+// recv returns the next text message, skipping pings.
+func (c *wsClient) recv(t *testing.T) map[string]any {
+	t.Helper()
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		size := int(hdr[1] & 0x7f)
+		if size == 126 {
+			var ext [2]byte
+			_, _ = io.ReadFull(c.br, ext[:])
+			size = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			t.Fatalf("recv payload: %v", err)
+		}
+		if hdr[0]&0x0f != 0x1 {
+			continue
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("decode %q: %v", payload, err)
+		}
+		return msg
+	}
+}
+
+// sync waits for the reply to an invalid filter. The handler subscribes
+// before it reads and reads messages in order, so once the reply arrives no
+// later event is missed and the filters sent before it are in effect.
+func (c *wsClient) sync(t *testing.T) {
+	t.Helper()
+	c.send(t, "{")
+	if msg := c.recv(t); msg["error"] == nil {
+		t.Fatalf("expected an error reply, got %v", msg)
+	}
+}
+
+func TestEventsSocketFiltersAndReplacesFilter(t *testing.T) {
+	h, st, _ := newTestHandler(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for _, id := range []string{"a", "b"} {
+		st.Put(&storage.Task{ID: id, Status: "running", Parts: []storage.FilePart{{URL: "https://example.com/" + id}}})
+	}
+	pause := func(id string) {
+		if rec := serve(h, http.MethodPost, "/tasks/"+id+"/pause", "", nil); rec.Code != http.StatusOK {
+			t.Fatalf("pause %s: %d %s", id, rec.Code, rec.Body)
+		}
+	}
+	resume := func(id string) {
+		if rec := serve(h, http.MethodPost, "/tasks/"+id+"/resume", "", nil); rec.Code != http.StatusAccepted {
+			t.Fatalf("resume %s: %d %s", id, rec.Code, rec.Body)
+		}
+	}
+
+	byTask := dialEvents(t, srv, "task_id=b&type=task")
+	byStatus := dialEvents(t, srv, "status=running")
+	byTask.sync(t)
+	byStatus.sync(t)
+	pause("a")
+	pause("b")
+	resume("a")
+	if e := byTask.recv(t); e["task_id"] != "b" || e["status"] != "paused" {
+		t.Fatalf("expected only the events of task b, got %v", e)
+	}
+	if e := byStatus.recv(t); e["task_id"] != "a" || e["status"] != "running" {
+		t.Fatalf("expected only running events, got %v", e)
+	}
+
+	// A filter sent over the socket replaces the one from the query.
+	byTask.send(t, `{"task_ids":["a"],"statuses":["paused"]}`)
+	byTask.sync(t)
+	resume("b")
+	pause("a")
+	if e := byTask.recv(t); e["task_id"] != "a" || e["status"] != "paused" {
+		t.Fatalf("expected the new filter to apply, got %v", e)
+	}
+}
+
+func TestEventsSocketReportsMissedEvents(t *testing.T) {
+	h, st, _ := newTestHandler(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	st.Put(&storage.Task{ID: "a", Status: "running", Parts: []storage.FilePart{{URL: "https://example.com/a"}}})
+
+	c := dialEvents(t, srv, "")
+	c.sync(t)
+	serve(h, http.MethodPost, "/tasks/a/pause", "", nil)
+	paused := c.recv(t)
+	serve(h, http.MethodPost, "/tasks/a/resume", "", nil)
+	resumed := c.recv(t)
+
+	// A kept ID replays what came after it.
+	since := strconv.FormatUint(uint64(paused["id"].(float64)), 10)
+	if e := dialEvents(t, srv, "last_event_id="+since).recv(t); e["id"] != resumed["id"] {
+		t.Fatalf("expected the backlog after %s, got %v", since, e)
+	}
+	// An ID from an earlier run asks the client to reload.
+	e := dialEvents(t, srv, "last_event_id=1").recv(t)
+	if e["type"] != "missed" || e["id"] != resumed["id"] {
+		t.Fatalf("expected a missed notice up to %v, got %v", resumed["id"], e)
+	}
+}
+
+func TestEventsSocketSendsProgressOnlyWhenAsked(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8192")
+		for range 8 {
+			_, _ = w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer origin.Close()
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	mgr := downloader.NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+	h := NewHandler(st, mgr).Router()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	all := dialEvents(t, srv, "")
+	progress := dialEvents(t, srv, "type=progress")
+	all.sync(t)
+	progress.sync(t)
+	rec := serve(h, http.MethodPost, "/tasks", `{"urls":["`+origin.URL+`/f.bin"]}`, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+
+	if e := progress.recv(t); e["type"] != "progress" || e["bytes_done"] == nil {
+		t.Fatalf("expected a progress event, got %v", e)
+	}
+	for {
+		e := all.recv(t)
+		if e["type"] == "progress" {
+			t.Fatalf("progress sent without being asked for: %v", e)
+		}
+		if e["type"] == "task" && e["status"] == "done" {
+			break
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ArchiveFormat is the container a task is packed into by Archive.Write.
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ParseArchiveFormat accepts "zip" (the default when empty), "tar.gz" and
+// "tgz".
+func ParseArchiveFormat(v string) (ArchiveFormat, error) {
+	switch v {
+	case "", "zip":
+		return ArchiveZip, nil
+	case "tar.gz", "tgz":
+		return ArchiveTarGz, nil
+	}
+	return "", fmt.Errorf("unknown archive format %q, want zip or tar.gz", v)
+}
+
+// ContentType is the MIME type of archives in this format.
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// partialSuffix marks unfinished files inside an archive so they are not
+// mistaken for complete ones once extracted.
+const partialSuffix = ".partial"
+
+// ArchiveEntry describes one part of the task in the archive manifest. Path
+// is empty for parts without a file in the archive.
+type ArchiveEntry struct {
+	Index    int    `json:"index"`
+	URL      string `json:"url"`
+	Path     string `json:"path,omitempty"`
+	Status   string `json:"status"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+	Partial  bool   `json:"partial,omitempty"`
+}
+
+// ArchiveManifest is stored as manifest.json in front of the files.
+type ArchiveManifest struct {
+	TaskID    string         `json:"task_id"`
+	Status    string         `json:"status"`
+	CreatedAt int64          `json:"created_at"`
+	Files     []ArchiveEntry `json:"files"`
+}
+
+// Archive is a snapshot of what a task has on disk, ready to be streamed.
+type Archive struct {
+	Manifest ArchiveManifest
+	files    []archiveFile
+}
+
+type archiveFile struct {
+	name    string // inside the archive
+	src     string
+	size    int64
+	modTime time.Time
+}
+
+// Archive collects the files of a task: every done part and, with partial,
+// whatever unfinished parts have downloaded so far. Parts that failed
+// verification are only listed in the manifest. Sizes are taken now; the
+// files are read by Write.
+func (m *Manager) Archive(taskID string, partial bool) (*Archive, error) {
+	task, ok := m.storage.Get(taskID)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	a := &Archive{Manifest: ArchiveManifest{TaskID: task.ID, Status: task.Status, CreatedAt: task.CreatedAt}}
+	for i, p := range task.Parts {
+		entry := ArchiveEntry{Index: i, URL: p.URL, Status: p.Status, Size: p.BytesDone, Checksum: p.Checksum}
+		src, name := m.finalPath(p.FileName), p.FileName
+		switch {
+		case p.Status == "done":
+		case partial && p.Status != "verify_failed":
+			src, name = m.stagingPath(p.FileName), p.FileName+partialSuffix
+			entry.Partial = true
+		default:
+			src = ""
+		}
+		if src != "" {
+			if info, err := os.Stat(src); err == nil && info.Mode().IsRegular() {
+				entry.Path, entry.Size = name, info.Size()
+				a.files = append(a.files, archiveFile{name: name, src: src, size: info.Size(), modTime: info.ModTime()})
+			}
+		}
+		a.Manifest.Files = append(a.Manifest.Files, entry)
+	}
+	return a, nil
+}
+
+// manifestName avoids clashing with a downloaded file of the same name.
+func (a *Archive) manifestName() string {
+	name := "manifest.json"
+	for taken := true; taken; {
+		taken = false
+		for _, f := range a.files {
+			if f.name == name {
+				name, taken = "_"+name, true
+				break
+			}
+		}
+	}
+	return name
+}
+
+// Write streams the archive to w without copying the files anywhere first.
+// An error means w got a truncated archive.
+func (a *Archive) Write(w io.Writer, format ArchiveFormat) error {
+	manifest, err := json.MarshalIndent(a.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if format == ArchiveTarGz {
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		if err := tw.WriteHeader(&tar.Header{Name: a.manifestName(), Mode: 0o644, Size: int64(len(manifest)), ModTime: now}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(manifest); err != nil {
+			return err
+		}
+		for _, f := range a.files {
+			hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: f.size, ModTime: f.modTime}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if err := copyFile(tw, f); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+
+	zw := zip.NewWriter(w)
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: a.manifestName(), Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(manifest); err != nil {
+		return err
+	}
+	for _, f := range a.files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: f.modTime})
+		if err != nil {
+			return err
+		}
+		if err := copyFile(fw, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// copyFile writes exactly the size recorded in the manifest. A partial file
+// may still be growing; what came after the snapshot is left out.
+func copyFile(w io.Writer, f archiveFile) error {
+	src, err := os.Open(f.src)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := io.CopyN(w, src, f.size); err != nil {
+		return fmt.Errorf("archive %s: %w", f.name, err)
+	}
+	return nil
+}
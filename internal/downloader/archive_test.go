@@ -0,0 +1,125 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestParseArchiveFormat(t *testing.T) {
+	cases := map[string]ArchiveFormat{"": ArchiveZip, "zip": ArchiveZip, "tar.gz": ArchiveTarGz, "tgz": ArchiveTarGz}
+	for in, want := range cases {
+		if got, err := ParseArchiveFormat(in); err != nil || got != want {
+			t.Fatalf("ParseArchiveFormat(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseArchiveFormat("rar"); err == nil {
+		t.Fatalf("expected unknown format to be rejected")
+	}
+}
+
+// archiveFixture stores a task with a done part, an unfinished one and one
+// that failed verification, with their files on disk.
+func archiveFixture(t *testing.T) *Manager {
+	t.Helper()
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	mgr := NewManager(st, tmp, 0)
+	t.Cleanup(mgr.Shutdown)
+	st.Put(&storage.Task{ID: "t1", Status: "running", Parts: []storage.FilePart{
+		{URL: "https://example.com/a.txt", FileName: "a.txt", Status: "done", BytesDone: 5, Checksum: "sha256:aa"},
+		{URL: "https://example.com/b.txt", FileName: "sub/b.txt", Status: "pending", BytesDone: 3},
+		{URL: "https://example.com/c.txt", FileName: "c.txt", Status: "verify_failed"},
+	}})
+	_ = os.MkdirAll(filepath.Join(tmp, "sub"), 0o755)
+	_ = os.WriteFile(mgr.finalPath("a.txt"), []byte("hello"), 0o644)
+	_ = os.WriteFile(mgr.stagingPath("sub/b.txt"), []byte("par"), 0o644)
+	return mgr
+}
+
+func TestManagerArchiveZip(t *testing.T) {
+	mgr := archiveFixture(t)
+	a, err := mgr.Archive("t1", false)
+	if err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := a.Write(&buf, ArchiveZip); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(data)
+	}
+	if len(got) != 2 || got["a.txt"] != "hello" {
+		t.Fatalf("unexpected zip contents %v", got)
+	}
+	var manifest ArchiveManifest
+	if err := json.Unmarshal([]byte(got["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if len(manifest.Files) != 3 || manifest.Files[0].Path != "a.txt" || manifest.Files[0].Checksum != "sha256:aa" || manifest.Files[1].Path != "" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	if _, err := mgr.Archive("missing", false); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestManagerArchiveTarGzWithPartialFiles(t *testing.T) {
+	mgr := archiveFixture(t)
+	a, err := mgr.Archive("t1", true)
+	if err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := a.Write(&buf, ArchiveTarGz); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(data)
+	}
+	if len(got) != 3 || got["a.txt"] != "hello" || got["sub/b.txt"+partialSuffix] != "par" {
+		t.Fatalf("unexpected tar contents %v", got)
+	}
+	if e := a.Manifest.Files[1]; !e.Partial || e.Size != 3 {
+		t.Fatalf("expected partial entry in manifest, got %+v", e)
+	}
+	if e := a.Manifest.Files[2]; e.Path != "" {
+		t.Fatalf("file failing verification must not be archived, got %+v", e)
+	}
+}
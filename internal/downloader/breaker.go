@@ -0,0 +1,249 @@
+package downloader
+
+import (
+	"sort"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// BreakerPolicy controls the per-host circuit breaker. The breaker opens
+// once Threshold of the last Window downloads from a host failed with a
+// host-level error and keeps the host's parts deferred for the cooldown.
+// Every consecutive opening doubles the cooldown up to MaxCooldown.
+type BreakerPolicy struct {
+	Threshold   int // 0 disables the breaker
+	Window      int
+	Cooldown    time.Duration
+	MaxCooldown time.Duration // 0 means the default of 10 minutes
+}
+
+// DefaultBreakerPolicy opens after 5 failures out of the last 10 downloads
+// and waits 30s before probing the host again.
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{
+		Threshold:   5,
+		Window:      10,
+		Cooldown:    30 * time.Second,
+		MaxCooldown: 10 * time.Minute,
+	}
+}
+
+func (p BreakerPolicy) cooldown(opens int) time.Duration {
+	d := p.Cooldown
+	for i := 1; i < opens && d < p.MaxCooldown; i++ {
+		d *= 2
+	}
+	if d > p.MaxCooldown {
+		d = p.MaxCooldown
+	}
+	return d
+}
+
+// Breaker states as reported by HostStats.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// breaker is the state of one host. Closed breakers without recent failures
+// are dropped, so the map only holds hosts that are in trouble.
+type breaker struct {
+	state     string
+	results   []bool // recent outcomes, true for a failure, oldest first
+	openUntil time.Time
+	opens     int  // consecutive openings, reset once a probe succeeds
+	probing   bool // a half-open probe is in flight
+}
+
+func (b *breaker) failures() int {
+	n := 0
+	for _, failed := range b.results {
+		if failed {
+			n++
+		}
+	}
+	return n
+}
+
+// hostFailure reports whether err says something about the health of the
+// host rather than about the file: network trouble and retryable statuses.
+func hostFailure(err error) bool {
+	de := classify(err)
+	return de != nil && de.Retryable && (de.Code == CodeNetwork || de.Code == CodeHTTPStatus)
+}
+
+func (s *scheduler) setBreakerPolicy(p BreakerPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.Window < p.Threshold {
+		p.Window = p.Threshold
+	}
+	if p.MaxCooldown == 0 {
+		p.MaxCooldown = DefaultBreakerPolicy().MaxCooldown
+	}
+	if p.MaxCooldown < p.Cooldown {
+		p.MaxCooldown = p.Cooldown
+	}
+	s.breaker = p
+}
+
+// breakerFree reports whether the breaker lets a part of host start now.
+// An open breaker whose cooldown ran out turns half-open and lets a single
+// probe through. Callers hold s.mu.
+func (s *scheduler) breakerFree(host string, now time.Time) (free bool, at time.Time) {
+	b, ok := s.breakers[host]
+	if !ok {
+		return true, time.Time{}
+	}
+	if b.state == breakerOpen {
+		if now.Before(b.openUntil) {
+			return false, b.openUntil
+		}
+		b.state = breakerHalfOpen
+	}
+	if b.state == breakerHalfOpen && b.probing {
+		return false, time.Time{}
+	}
+	return true, time.Time{}
+}
+
+// report records the outcome of a download from host. If the breaker is
+// open afterwards, until is the end of the cooldown and opened tells
+// whether this very report tripped it.
+func (s *scheduler) report(host string, failed bool, now time.Time) (until time.Time, opened bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if host == "" || s.breaker.Threshold <= 0 {
+		return time.Time{}, false
+	}
+	b, ok := s.breakers[host]
+	if !ok {
+		if !failed {
+			return time.Time{}, false
+		}
+		b = &breaker{state: breakerClosed}
+		s.breakers[host] = b
+	}
+
+	switch b.state {
+	case breakerOpen:
+		// A download that started before the breaker opened.
+		return b.openUntil, false
+	case breakerHalfOpen:
+		if !failed {
+			delete(s.breakers, host)
+			return time.Time{}, false
+		}
+		s.trip(b, now)
+		return b.openUntil, true
+	}
+
+	b.results = append(b.results, failed)
+	if len(b.results) > s.breaker.Window {
+		b.results = b.results[len(b.results)-s.breaker.Window:]
+	}
+	switch n := b.failures(); {
+	case n >= s.breaker.Threshold:
+		s.trip(b, now)
+		return b.openUntil, true
+	case n == 0:
+		delete(s.breakers, host)
+	}
+	return time.Time{}, false
+}
+
+// trip opens the breaker. Callers hold s.mu.
+func (s *scheduler) trip(b *breaker, now time.Time) {
+	b.opens++
+	b.state = breakerOpen
+	b.openUntil = now.Add(s.breaker.cooldown(b.opens))
+	b.results = nil
+	b.probing = false
+}
+
+// HostStats describes the load and the breaker state of one host.
+type HostStats struct {
+	Host      string `json:"host"`
+	State     string `json:"state"` // closed, open or half_open
+	Active    int    `json:"active"`
+	Failures  int    `json:"failures"` // within the breaker window
+	Samples   int    `json:"samples"`
+	OpenUntil int64  `json:"open_until,omitempty"` // unix seconds
+	Opens     int    `json:"opens,omitempty"`
+}
+
+// hostStats lists every host that has parts in flight or a breaker that is
+// not plain closed and healthy, sorted by name.
+func (s *scheduler) hostStats(now time.Time) []HostStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byHost := make(map[string]*HostStats)
+	get := func(host string) *HostStats {
+		hs, ok := byHost[host]
+		if !ok {
+			hs = &HostStats{Host: host, State: breakerClosed}
+			byHost[host] = hs
+		}
+		return hs
+	}
+	for host, h := range s.hosts {
+		if h.active > 0 {
+			get(host).Active = h.active
+		}
+	}
+	for host, b := range s.breakers {
+		hs := get(host)
+		hs.State = b.state
+		if b.state == breakerOpen && !now.Before(b.openUntil) {
+			hs.State = breakerHalfOpen
+		}
+		if b.state == breakerOpen {
+			hs.OpenUntil = b.openUntil.Unix()
+		}
+		hs.Failures = b.failures()
+		hs.Samples = len(b.results)
+		hs.Opens = b.opens
+	}
+	out := make([]HostStats, 0, len(byHost))
+	for _, hs := range byHost {
+		out = append(out, *hs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// SetBreakerPolicy replaces the default circuit breaker policy. It must be
+// called before RestoreFromStorage starts the workers.
+func (m *Manager) SetBreakerPolicy(p BreakerPolicy) {
+	m.sched.setBreakerPolicy(p)
+}
+
+// HostStats returns the hosts currently being downloaded from or held back
+// by their circuit breaker.
+func (m *Manager) HostStats() []HostStats {
+	return m.sched.hostStats(time.Now())
+}
+
+// deferHost pushes the pending parts of host back to until and persists it,
+// so the cooldown survives a restart. Like in the scheduler, a part belongs
+// to the host its next attempt goes to, mirrors included. Parts already
+// queued are held back by the scheduler anyway.
+func (m *Manager) deferHost(host string, until time.Time) {
+	at := until.Unix()
+	for _, task := range m.storage.List() {
+		if finalStatus(task.Status) {
+			continue
+		}
+		m.storage.Update(task.ID, func(t *storage.Task) {
+			for i := range t.Parts {
+				p := &t.Parts[i]
+				if p.Status == "pending" && p.NextRetryAt < at && hostOf(sourceURL(*p)) == host {
+					p.NextRetryAt = at
+				}
+			}
+		})
+	}
+	_ = m.storage.Flush()
+}
@@ -0,0 +1,157 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	s := newScheduler()
+	s.setBreakerPolicy(BreakerPolicy{Threshold: 2, Window: 3, Cooldown: time.Minute})
+	now := time.Now()
+
+	if _, opened := s.report("h", true, now); opened {
+		t.Fatalf("a single failure must not open the breaker")
+	}
+	until, opened := s.report("h", true, now)
+	if !opened || !until.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected the breaker to open until %v, got %v (opened=%v)", now.Add(time.Minute), until, opened)
+	}
+
+	s.push(job{taskID: "a", part: 0, host: "h"}, PriorityNormal)
+	s.push(job{taskID: "a", part: 1, host: "h"}, PriorityNormal)
+	s.mu.Lock()
+	_, ok, retryAt := s.take(now)
+	s.mu.Unlock()
+	if ok || !retryAt.Equal(until) {
+		t.Fatalf("expected parts to be deferred until %v, got ok=%v retryAt=%v", until, ok, retryAt)
+	}
+
+	// After the cooldown a single probe goes through.
+	later := until.Add(time.Second)
+	s.mu.Lock()
+	probe, ok, _ := s.take(later)
+	_, second, _ := s.take(later)
+	s.mu.Unlock()
+	if !ok || second {
+		t.Fatalf("expected exactly one probe, got ok=%v second=%v", ok, second)
+	}
+	if stats := s.hostStats(later); len(stats) != 1 || stats[0].State != breakerHalfOpen || stats[0].Active != 1 {
+		t.Fatalf("unexpected host stats %+v", stats)
+	}
+
+	// A failed probe reopens with a doubled cooldown.
+	until, opened = s.report(probe.host, true, later)
+	s.release(probe)
+	if !opened || !until.Equal(later.Add(2*time.Minute)) {
+		t.Fatalf("expected a doubled cooldown, got %v", until.Sub(later))
+	}
+
+	// A successful probe closes the breaker and forgets the host.
+	s.mu.Lock()
+	probe, _, _ = s.take(until)
+	s.mu.Unlock()
+	s.report(probe.host, false, until)
+	s.release(probe)
+	if stats := s.hostStats(until); len(stats) != 0 {
+		t.Fatalf("expected a healthy host to be dropped, got %+v", stats)
+	}
+}
+
+func TestDeferHostFollowsMirrors(t *testing.T) {
+	st, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	st.Put(&storage.Task{ID: "t1", Status: "running", Parts: []storage.FilePart{
+		// Next attempt goes to the healthy mirror.
+		{URL: "https://bad.example/a", Mirrors: []string{"https://good.example/a"}, Attempts: 1, Status: "pending"},
+		// Next attempt goes to the tripped mirror.
+		{URL: "https://good.example/b", Mirrors: []string{"https://bad.example/b"}, Attempts: 1, Status: "pending"},
+		{URL: "https://bad.example/c", Status: "pending"},
+	}})
+	mgr := NewManager(st, t.TempDir(), 0)
+	until := time.Now().Add(time.Hour)
+	mgr.deferHost("bad.example", until)
+
+	task, _ := st.Get("t1")
+	for i, want := range []int64{0, until.Unix(), until.Unix()} {
+		if got := task.Parts[i].NextRetryAt; got != want {
+			t.Fatalf("part %d: next_retry_at %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestBreakerDefersHostAcrossRestart(t *testing.T) {
+	tmp := t.TempDir()
+	statePath := filepath.Join(tmp, "state", "tasks.json")
+	st, err := storage.NewFileStorage(statePath)
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	mgr.SetBreakerPolicy(BreakerPolicy{Threshold: 2, Window: 5, Cooldown: time.Hour})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	urls := []string{srv.URL + "/1.bin", srv.URL + "/2.bin", srv.URL + "/3.bin"}
+	task, err := mgr.CreateTask(context.Background(), partSpecs(urls...), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		stats := mgr.HostStats()
+		if len(stats) == 1 && stats[0].State == breakerOpen {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("breaker did not open, stats %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mgr.Shutdown()
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("expected the host to be left alone after 2 failures, got %d requests", n)
+	}
+
+	stored, _ := st.Get(task.ID)
+	minAt := time.Now().Add(50 * time.Minute).Unix()
+	for i, p := range stored.Parts {
+		if p.Status != "pending" || p.NextRetryAt < minAt {
+			t.Fatalf("part %d not deferred: status %q next_retry_at %d", i, p.Status, p.NextRetryAt)
+		}
+	}
+
+	// The deferral is persisted, a restart does not hit the host again.
+	st2, err := storage.NewFileStorage(statePath)
+	if err != nil {
+		t.Fatalf("storage reload: %v", err)
+	}
+	mgr2 := NewManager(st2, tmp, 1)
+	if err := mgr2.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mgr2.Shutdown()
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("restart hit the deferred host again: %d requests", n)
+	}
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// defaultChecksumAlgo is computed for parts that come without an expected
+// digest, so every finished file has one on record.
+const defaultChecksumAlgo = "sha256"
+
+var checksumSizes = map[string]int{"sha256": sha256.Size, "sha1": sha1.Size, "md5": md5.Size}
+
+// ParseChecksum validates an expected digest written as "algo:hex" (sha256,
+// sha1 or md5) and returns it normalized. A bare hex digest is accepted
+// too, the algorithm is then picked by its length.
+func ParseChecksum(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return "", nil
+	}
+	algo, sum, ok := strings.Cut(v, ":")
+	if !ok {
+		sum = v
+		algo = ""
+		for a, size := range checksumSizes {
+			if len(sum) == hex.EncodedLen(size) {
+				algo = a
+			}
+		}
+	}
+	size, known := checksumSizes[algo]
+	if !known {
+		return "", fmt.Errorf("invalid checksum %q: want sha256, sha1 or md5 as algo:hex", v)
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != size {
+		return "", fmt.Errorf("invalid checksum %q: want %d hex digits for %s", v, hex.EncodedLen(size), algo)
+	}
+	return algo + ":" + sum, nil
+}
+
+// checksumAlgo returns the algorithm a part is hashed with.
+func checksumAlgo(part storage.FilePart) string {
+	if algo, _, ok := strings.Cut(part.ExpectedChecksum, ":"); ok {
+		return algo
+	}
+	return defaultChecksumAlgo
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case "sha1":
+		return sha1.New()
+	case "md5":
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+func formatChecksum(algo string, h hash.Hash) string {
+	return algo + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile feeds the first n bytes of the file at path into h, or the
+// whole file when n < 0.
+func hashFile(path string, n int64, h hash.Hash) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var src io.Reader = f
+	if n >= 0 {
+		src = io.LimitReader(f, n)
+	}
+	copied, err := io.Copy(h, src)
+	if err != nil {
+		return err
+	}
+	if n >= 0 && copied < n {
+		return fmt.Errorf("hash %s: file is shorter than %d bytes", path, n)
+	}
+	return nil
+}
+
+// errVerifyFailed marks a completely downloaded file that does not match the
+// size or digest given at task creation. It is not retried.
+var errVerifyFailed = errors.New("verification failed")
+
+// verifyPart records the computed digest of a finished part and checks it,
+// together with the file size, against what the task asked for.
+func (m *Manager) verifyPart(taskID string, idx int, part storage.FilePart, dstPath, sum string) error {
+	m.updatePart(taskID, idx, func(p *storage.FilePart) { p.Checksum = sum })
+	if part.ExpectedSize > 0 {
+		if size := fileSize(dstPath); size != part.ExpectedSize {
+			return validationError("%w: size %d, expected %d", errVerifyFailed, size, part.ExpectedSize)
+		}
+	}
+	if part.ExpectedChecksum != "" && sum != part.ExpectedChecksum {
+		return validationError("%w: checksum %s, expected %s", errVerifyFailed, sum, part.ExpectedChecksum)
+	}
+	return nil
+}
+
+// quarantine moves a file that failed verification out of the download
+// directory and records where it went.
+func (m *Manager) quarantine(taskID string, idx int) {
+	task, ok := m.storage.Get(taskID)
+	if !ok {
+		return
+	}
+	name := task.Parts[idx].FileName
+	dst := filepath.Join(m.quarantineDir, taskID+"-"+strings.ReplaceAll(name, "/", "_"))
+	if err := os.MkdirAll(m.quarantineDir, 0o755); err != nil {
+		return
+	}
+	if err := os.Rename(m.stagingPath(name), dst); err != nil {
+		return
+	}
+	m.updatePart(taskID, idx, func(p *storage.FilePart) { p.QuarantinePath = dst })
+}
+
+// SetQuarantineDir sets where files failing verification are moved to.
+// It must be called before RestoreFromStorage starts the workers.
+func (m *Manager) SetQuarantineDir(dir string) {
+	m.quarantineDir = dir
+}
@@ -0,0 +1,157 @@
+package downloader
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestParseChecksum(t *testing.T) {
+	sha := strings.Repeat("ab", sha256.Size)
+	cases := map[string]string{
+		"":                               "",
+		"SHA256:" + sha:                  "sha256:" + sha,
+		sha:                              "sha256:" + sha,
+		strings.Repeat("0", 40):          "sha1:" + strings.Repeat("0", 40),
+		"md5:" + strings.Repeat("f", 32): "md5:" + strings.Repeat("f", 32),
+	}
+	for in, want := range cases {
+		got, err := ParseChecksum(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseChecksum(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"sha512:" + sha, "sha256:abc", "md5:" + strings.Repeat("z", 32), "1234"} {
+		if _, err := ParseChecksum(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func sha256Sum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestChecksumVerifiedAcrossResume(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	payload := []byte(strings.Repeat("resume-me ", 200))
+	srv, requests := newRangeServer(t, payload, true)
+
+	// Half of the file is already on disk from an earlier run.
+	if err := os.WriteFile(filepath.Join(tmp, "r.bin"), payload[:700], 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "sum-task",
+		Status: "running",
+		Parts: []storage.FilePart{{
+			URL:              srv.URL + "/r.bin",
+			FileName:         "r.bin",
+			BytesDone:        700,
+			Status:           "downloading",
+			ExpectedSize:     int64(len(payload)),
+			ExpectedChecksum: sha256Sum(payload),
+		}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "sum-task", "done")
+	mgr.Shutdown()
+
+	if got := stored.Parts[0].Checksum; got != sha256Sum(payload) {
+		t.Fatalf("unexpected computed checksum %q", got)
+	}
+	if reqs := requests(); len(reqs) != 1 || reqs[0] != "GET bytes=700-" {
+		t.Fatalf("expected a single resumed request, got %v", reqs)
+	}
+}
+
+func TestChecksumOfSegmentedDownload(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	payload := []byte(strings.Repeat("0123456789", 100))
+	srv, _ := newRangeServer(t, payload, true)
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetSegments(4)
+	mgr.minSegmentSize = 100
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	sum := md5.Sum(payload)
+	want := "md5:" + hex.EncodeToString(sum[:])
+	task, err := mgr.CreateTask(context.Background(), []PartSpec{{URL: srv.URL + "/seg.bin", Checksum: want}}, TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+	mgr.Shutdown()
+
+	if p := stored.Parts[0]; len(p.Segments) != 4 || p.Checksum != want {
+		t.Fatalf("expected a verified segmented download, got %+v", p)
+	}
+}
+
+func TestChecksumMismatchQuarantinesFile(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	payload := []byte("tampered contents")
+	srv, requests := newRangeServer(t, payload, false)
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := mgr.CreateTask(context.Background(), []PartSpec{
+		{URL: srv.URL + "/bad.bin", Checksum: sha256Sum([]byte("original contents"))},
+		{URL: srv.URL + "/short.bin", Size: 1 << 20},
+	}, TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "partial")
+	mgr.Shutdown()
+
+	for i, p := range stored.Parts {
+		if p.Status != "verify_failed" || p.ErrorCode != string(CodeValidation) || p.Retryable {
+			t.Fatalf("part %d: expected a non-retryable verify_failed, got %+v", i, p)
+		}
+		if pathExists(filepath.Join(tmp, p.FileName)) {
+			t.Fatalf("part %d: file left in the download dir", i)
+		}
+		if p.QuarantinePath != filepath.Join(tmp, ".quarantine", task.ID+"-"+p.FileName) {
+			t.Fatalf("part %d: unexpected quarantine path %q", i, p.QuarantinePath)
+		}
+		data, err := os.ReadFile(p.QuarantinePath)
+		if err != nil || string(data) != string(payload) {
+			t.Fatalf("part %d: quarantined file not found: %v", i, err)
+		}
+	}
+	if stored.Parts[0].Checksum != sha256Sum(payload) {
+		t.Fatalf("expected the computed checksum to be recorded, got %q", stored.Parts[0].Checksum)
+	}
+	if n := len(requests()); n != 2 {
+		t.Fatalf("verification failures must not be retried, got %d requests", n)
+	}
+}
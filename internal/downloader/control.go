@@ -0,0 +1,205 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+var (
+	ErrTaskNotFound  = errors.New("task not found")
+	ErrTaskFinished  = errors.New("task already finished")
+	ErrTaskNotPaused = errors.New("task is not paused")
+	ErrQueueFull     = errors.New("download queue is full")
+	ErrTaskTooLarge  = errors.New("task has more files than the queue can hold")
+	ErrPartNotFound  = errors.New("file not found in task")
+	ErrPartNotDone   = errors.New("file is not downloaded yet")
+
+	errTaskCancelled = errors.New("task cancelled")
+	errTaskPaused    = errors.New("task paused")
+)
+
+// activeTask tracks the parts of a task that workers are downloading right
+// now. They share one context so stopping the task aborts all of them.
+type activeTask struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	parts  int
+	idle   chan struct{} // closed when the last part lets go
+}
+
+// finalStatus reports whether a task in this status will not be processed
+// any more.
+func finalStatus(status string) bool {
+	switch status {
+	case "done", "partial", "error", "cancelled":
+		return true
+	}
+	return false
+}
+
+// partFinished reports whether a part in this status is never downloaded
+// again.
+func partFinished(status string) bool {
+	switch status {
+	case "done", "error", "cancelled", "verify_failed":
+		return true
+	}
+	return false
+}
+
+// begin registers a part of the task as in progress and returns a fresh copy
+// of the task with a context that is cancelled when the task is stopped from
+// outside. It returns a nil task if the task is gone, paused or cancelled.
+func (m *Manager) begin(id string) (context.Context, *storage.Task, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	task, ok := m.storage.Get(id)
+	if !ok || task.Status == "cancelled" || task.Status == "paused" {
+		return nil, nil, nil
+	}
+	at, ok := m.active[id]
+	if !ok {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		at = &activeTask{ctx: ctx, cancel: cancel, idle: make(chan struct{})}
+		m.active[id] = at
+	}
+	at.parts++
+	return at.ctx, task, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		at.parts--
+		if at.parts == 0 {
+			delete(m.active, id)
+			at.cancel(nil)
+			close(at.idle)
+		}
+	}
+}
+
+// stop switches a task that is still in progress to status and waits until
+// no worker touches it any more. The in-flight request is aborted with cause.
+func (m *Manager) stop(id, status string, cause error) error {
+	m.mu.Lock()
+	var prev string
+	found := m.mutate(id, func(t *storage.Task) {
+		prev = t.Status
+		if !finalStatus(t.Status) {
+			t.Status = status
+		}
+	})
+	at := m.active[id]
+	m.mu.Unlock()
+
+	if !found {
+		return ErrTaskNotFound
+	}
+	if finalStatus(prev) {
+		return ErrTaskFinished
+	}
+	m.sched.remove(id)
+	if at != nil {
+		at.cancel(cause)
+		<-at.idle
+	}
+	return nil
+}
+
+// Cancel stops a task: the in-flight request is aborted, unfinished parts
+// are marked cancelled and, with purge, their partial files are removed.
+func (m *Manager) Cancel(id string, purge bool) (*storage.Task, error) {
+	if err := m.stop(id, "cancelled", errTaskCancelled); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	m.update(id, func(t *storage.Task) {
+		for i := range t.Parts {
+			p := &t.Parts[i]
+			if p.Status == "done" || p.Status == "verify_failed" {
+				continue
+			}
+			p.Status = "cancelled"
+			p.NextRetryAt = 0
+			setPartError(p, &Error{Code: CodeCancelled, Err: errTaskCancelled})
+			if purge {
+				paths = append(paths, m.stagingPath(p.FileName))
+				p.BytesDone = 0
+				p.Segments = nil
+				p.ETag, p.LastModified = "", ""
+			}
+		}
+	})
+	for _, path := range paths {
+		_ = os.Remove(path)
+	}
+	m.dropLimiter(id)
+	m.hooks.dropSecret(id)
+
+	task, _ := m.storage.Get(id)
+	return task, nil
+}
+
+// Pause parks a task. The worker stops mid-stream after syncing what it has
+// written, so Resume continues from the same Range offset.
+func (m *Manager) Pause(id string) (*storage.Task, error) {
+	if err := m.stop(id, "paused", errTaskPaused); err != nil {
+		return nil, err
+	}
+	m.update(id, func(t *storage.Task) {
+		for i := range t.Parts {
+			if t.Parts[i].Status == "downloading" {
+				t.Parts[i].Status = "pending"
+			}
+		}
+	})
+	task, _ := m.storage.Get(id)
+	return task, nil
+}
+
+// Resume puts a paused task back into the queue.
+func (m *Manager) Resume(id string) (*storage.Task, error) {
+	var prev string
+	found := m.mutate(id, func(t *storage.Task) {
+		prev = t.Status
+		if t.Status == "paused" {
+			t.Status = "running"
+		}
+	})
+	if !found {
+		return nil, ErrTaskNotFound
+	}
+	if prev != "paused" {
+		return nil, ErrTaskNotPaused
+	}
+	_ = m.storage.Flush()
+
+	task, _ := m.storage.Get(id)
+	m.enqueueTask(task)
+	return task, nil
+}
+
+// SetPriority changes the priority of a task that has not finished yet.
+// Its queued parts move to the new priority right away.
+func (m *Manager) SetPriority(id string, priority int) (*storage.Task, error) {
+	var prev string
+	found := m.mutate(id, func(t *storage.Task) {
+		prev = t.Status
+		if !finalStatus(t.Status) {
+			t.Priority = priority
+		}
+	})
+	if !found {
+		return nil, ErrTaskNotFound
+	}
+	if finalStatus(prev) {
+		return nil, ErrTaskFinished
+	}
+	_ = m.storage.Flush()
+	m.sched.setPriority(id, priority)
+
+	task, _ := m.storage.Get(id)
+	return task, nil
+}
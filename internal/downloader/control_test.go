@@ -0,0 +1,282 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// newStallingServer sends a first chunk of /slow and then holds the
+// connection until the client goes away. Other paths answer immediately.
+func newStallingServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var fastCalls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slow" {
+			fastCalls.Add(1)
+			_, _ = w.Write([]byte("fast"))
+			return
+		}
+		w.Header().Set("Content-Length", "1000000")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(make([]byte, 1000))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fastCalls
+}
+
+func waitPartProgress(t *testing.T, st *storage.FileStorage, id string, idx int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if task, ok := st.Get(id); ok && task.Parts[idx].BytesDone > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("part %d of %s made no progress", idx, id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerCancelAbortsInFlightDownload(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	srv, fastCalls := newStallingServer(t)
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/slow", srv.URL+"/fast"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitPartProgress(t, st, task.ID, 0)
+
+	cancelled, err := mgr.Cancel(task.ID, false)
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if cancelled.Status != "cancelled" {
+		t.Fatalf("expected task cancelled, got %q", cancelled.Status)
+	}
+	for i, p := range cancelled.Parts {
+		if p.Status != "cancelled" || p.ErrorCode != string(CodeCancelled) {
+			t.Fatalf("part %d: expected cancelled, got %+v", i, p)
+		}
+	}
+	if size := fileSize(filepath.Join(tmp, stagingName(cancelled.Parts[0].FileName))); size != 1000 {
+		t.Fatalf("expected partial file to be kept, got %d bytes", size)
+	}
+	if fastCalls.Load() != 0 {
+		t.Fatalf("remaining parts must not be downloaded after cancel")
+	}
+
+	if _, err := mgr.Cancel(task.ID, false); !errors.Is(err, ErrTaskFinished) {
+		t.Fatalf("expected ErrTaskFinished on second cancel, got %v", err)
+	}
+	if _, err := mgr.Cancel("missing", false); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestManagerCancelPurgesPartialFiles(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	srv, _ := newStallingServer(t)
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/slow"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitPartProgress(t, st, task.ID, 0)
+
+	cancelled, err := mgr.Cancel(task.ID, true)
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, stagingName(cancelled.Parts[0].FileName))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected partial file to be removed, stat err: %v", err)
+	}
+	if cancelled.Parts[0].BytesDone != 0 {
+		t.Fatalf("expected progress reset after purge, got %d", cancelled.Parts[0].BytesDone)
+	}
+}
+
+func TestManagerCancelledTaskIsNotRestored(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	// No workers: the task stays queued and is cancelled before it starts.
+	mgr := NewManager(st, tmp, 0)
+	task, err := mgr.CreateTask(context.Background(), partSpecs("https://example.com/a.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if _, err := mgr.Cancel(task.ID, false); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	mgr.Shutdown()
+
+	restarted := NewManager(st, tmp, 0)
+	if err := restarted.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if queued := queuedJobs(restarted.sched); len(queued) != 0 {
+		t.Fatalf("cancelled task must not be re-enqueued, got %+v", queued)
+	}
+	restarted.Shutdown()
+}
+
+func TestManagerPauseAndResumeContinuesFromOffset(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := make([]byte, 2000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	var ranges []string
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.Header.Get("Range") == "" {
+			// First attempt: half of the file, then the connection hangs.
+			w.Header().Set("Content-Length", "2000")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(payload[:1000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 1000-1999/2000")
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(payload[1000:])
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/big.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitPartProgress(t, st, task.ID, 0)
+
+	paused, err := mgr.Pause(task.ID)
+	if err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if paused.Status != "paused" || paused.Parts[0].Status != "pending" || paused.Parts[0].BytesDone != 1000 {
+		t.Fatalf("unexpected paused state: %+v", paused)
+	}
+	if size := fileSize(filepath.Join(tmp, stagingName(paused.Parts[0].FileName))); size != 1000 {
+		t.Fatalf("expected written bytes on disk, got %d", size)
+	}
+
+	// A restart while paused must not pick the task up.
+	restarted := NewManager(st, tmp, 0)
+	if err := restarted.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if queued := queuedJobs(restarted.sched); len(queued) != 0 {
+		t.Fatalf("paused task must not be re-enqueued, got %+v", queued)
+	}
+	restarted.Shutdown()
+
+	if _, err := mgr.Resume(task.ID); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+	data, err := os.ReadFile(filepath.Join(tmp, stored.Parts[0].FileName))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("unexpected file content after resume")
+	}
+	if calls.Load() != 2 || ranges[1] != "bytes=1000-" {
+		t.Fatalf("expected resume from offset 1000, got %v", ranges)
+	}
+
+	if _, err := mgr.Resume(task.ID); !errors.Is(err, ErrTaskNotPaused) {
+		t.Fatalf("expected ErrTaskNotPaused, got %v", err)
+	}
+}
+
+func TestManagerRestoreKeepsPriorityOrder(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	pending := []storage.FilePart{{URL: "https://example.com/x", FileName: "x", Status: "pending"}}
+	st.Put(&storage.Task{ID: "old-normal", CreatedAt: 1, Status: "running", Parts: pending})
+	st.Put(&storage.Task{ID: "new-normal", CreatedAt: 2, Status: "running", Parts: pending})
+	st.Put(&storage.Task{ID: "new-high", CreatedAt: 3, Status: "running", Priority: PriorityHigh, Parts: pending})
+	st.Put(&storage.Task{ID: "low", CreatedAt: 0, Status: "running", Priority: PriorityLow, Parts: pending})
+
+	mgr := NewManager(st, tmp, 0)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	if _, err := mgr.SetPriority("old-normal", PriorityLow); err != nil {
+		t.Fatalf("set priority: %v", err)
+	}
+	if stored, _ := st.Get("old-normal"); stored.Priority != PriorityLow {
+		t.Fatalf("expected priority to be persisted, got %d", stored.Priority)
+	}
+	if _, err := mgr.SetPriority("missing", PriorityHigh); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	want := []string{"new-high", "new-normal", "low", "old-normal"}
+	got := queuedJobs(mgr.sched)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d jobs, got %+v", len(want), got)
+	}
+	for i, id := range want {
+		if got[i].taskID != id {
+			t.Fatalf("position %d: expected %s, got %+v", i, id, got)
+		}
+	}
+}
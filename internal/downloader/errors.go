@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrorCode is the machine-readable class of a download failure.
+type ErrorCode string
+
+const (
+	CodeNetwork    ErrorCode = "network"     // DNS, connect, reset, short body
+	CodeHTTPStatus ErrorCode = "http_status" // origin answered with an unexpected status
+	CodeValidation ErrorCode = "validation"  // response does not match what was announced or expected
+	CodeDisk       ErrorCode = "disk"        // local file system trouble
+	CodeCancelled  ErrorCode = "cancelled"   // stopped on purpose
+	CodePolicy     ErrorCode = "policy"      // refused by our own rules
+)
+
+// Error is a classified download failure. Every error leaving downloadPart is
+// an *Error, so the retry logic and API consumers never parse messages.
+type Error struct {
+	Code       ErrorCode
+	Retryable  bool
+	HTTPStatus int           // set for CodeHTTPStatus
+	RetryAfter time.Duration // server-requested delay, if any
+	Err        error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func newHTTPStatusError(resp *http.Response) *Error {
+	e := &Error{
+		Code:       CodeHTTPStatus,
+		HTTPStatus: resp.StatusCode,
+		Retryable:  retryableStatus(resp.StatusCode),
+		Err:        fmt.Errorf("unexpected status: %s", resp.Status),
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return e
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+func policyError(format string, args ...any) *Error {
+	return &Error{Code: CodePolicy, Err: fmt.Errorf(format, args...)}
+}
+
+func validationError(format string, args ...any) *Error {
+	return &Error{Code: CodeValidation, Err: fmt.Errorf(format, args...)}
+}
+
+// classify wraps err into an *Error. Unknown failures are treated as
+// transient network trouble.
+func classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var de *Error
+	if errors.As(err, &de) {
+		if de == err {
+			return de
+		}
+		// Keep the outer context in the message, but the inner class.
+		c := *de
+		c.Err = err
+		return &c
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: CodeCancelled, Err: err}
+	case errors.Is(err, errIncomplete):
+		return &Error{Code: CodeNetwork, Retryable: true, Err: err}
+	case errors.Is(err, errRemoteChanged):
+		return &Error{Code: CodeValidation, Retryable: true, Err: err}
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return &Error{Code: CodeNetwork, Retryable: true, Err: err}
+	}
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &Error{Code: CodeDisk, Err: err}
+	}
+	return &Error{Code: CodeNetwork, Retryable: true, Err: err}
+}
@@ -0,0 +1,80 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		code      ErrorCode
+		retryable bool
+	}{
+		{"dns", &net.DNSError{Err: "no such host", Name: "nope.invalid"}, CodeNetwork, true},
+		{"short body", fmt.Errorf("%w: got 1 of 2 bytes", errIncomplete), CodeNetwork, true},
+		{"remote changed", errRemoteChanged, CodeValidation, true},
+		{"disk", &fs.PathError{Op: "write", Path: "/data/x", Err: errors.New("no space left on device")}, CodeDisk, false},
+		{"cancelled", context.Canceled, CodeCancelled, false},
+		{"policy", policyError("nope"), CodePolicy, false},
+		{"unknown", errors.New("something odd"), CodeNetwork, true},
+		{"wrapped status", fmt.Errorf("segment 1: %w", &Error{Code: CodeHTTPStatus, HTTPStatus: 404, Err: errors.New("404")}), CodeHTTPStatus, false},
+	}
+	for _, tc := range cases {
+		got := classify(tc.err)
+		if got.Code != tc.code || got.Retryable != tc.retryable {
+			t.Errorf("%s: expected %s/%v, got %s/%v", tc.name, tc.code, tc.retryable, got.Code, got.Retryable)
+		}
+		if got.Error() != tc.err.Error() {
+			t.Errorf("%s: message changed to %q", tc.name, got.Error())
+		}
+	}
+	if classify(nil) != nil {
+		t.Fatalf("expected nil for nil error")
+	}
+}
+
+func TestNewHTTPStatusError(t *testing.T) {
+	resp := &http.Response{StatusCode: 429, Status: "429 Too Many Requests", Header: http.Header{"Retry-After": {"7"}}}
+	e := newHTTPStatusError(resp)
+	if e.Code != CodeHTTPStatus || !e.Retryable || e.HTTPStatus != 429 || e.RetryAfter.Seconds() != 7 {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+	resp = &http.Response{StatusCode: 403, Status: "403 Forbidden", Header: http.Header{}}
+	if e := newHTTPStatusError(resp); e.Retryable {
+		t.Fatalf("403 must not be retryable")
+	}
+}
+
+func TestManagerRecordsPolicyError(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := mgr.CreateTask(context.Background(), partSpecs("ftp://example.com/file.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "partial")
+	mgr.Shutdown()
+
+	p := stored.Parts[0]
+	if p.ErrorCode != string(CodePolicy) || p.Retryable || p.Attempts != 1 {
+		t.Fatalf("expected a single non-retryable policy error, got %+v", p)
+	}
+}
@@ -0,0 +1,193 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+const (
+	// progressInterval bounds how often a downloading part reports progress.
+	progressInterval = 500 * time.Millisecond
+	// eventHistory is how many state transitions are kept for subscribers
+	// that reconnect with the last event they saw.
+	eventHistory = 1024
+	// subscriberBuffer is how far a subscriber may fall behind before it is
+	// dropped; it catches up by subscribing again from its last event.
+	subscriberBuffer = 256
+)
+
+// Event types.
+const (
+	EventCreated  = "created"  // task created
+	EventTask     = "task"     // task status changed
+	EventPart     = "part"     // part status changed
+	EventProgress = "progress" // bytes downloaded, throttled
+)
+
+// Event is a change of a task as seen by subscribers. IDs grow by one with
+// every event of any task, starting from the boot time in microseconds, so
+// the IDs of one run never overlap those of an earlier one.
+type Event struct {
+	ID         uint64  `json:"id"`
+	Type       string  `json:"type"`
+	TaskID     string  `json:"task_id"`
+	Part       *int    `json:"part,omitempty"`
+	Status     string  `json:"status,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	ErrorCode  string  `json:"error_code,omitempty"`
+	BytesDone  int64   `json:"bytes_done,omitempty"`
+	BytesTotal int64   `json:"bytes_total,omitempty"`
+	Speed      float64 `json:"speed,omitempty"` // bytes per second
+	ETA        int64   `json:"eta,omitempty"`   // seconds left
+	Time       int64   `json:"time"`
+}
+
+// Subscription delivers the events matching its filter. C is closed when
+// the subscriber falls too far behind or Close is called.
+type Subscription struct {
+	C <-chan Event
+	// Backlog holds the kept transitions after the ID passed to Subscribe.
+	Backlog []Event
+	// Missed is set when some of the events after that ID are no longer
+	// kept or the ID comes from before a restart, so the subscriber has to
+	// look at the current state instead.
+	Missed bool
+	// LastID is the ID of the latest event published before subscribing.
+	LastID uint64
+
+	bus   *eventBus
+	ch    chan Event
+	match func(Event) bool
+}
+
+// Close stops the delivery of events.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+type eventBus struct {
+	mu      sync.Mutex
+	start   uint64 // lastID before the first event of this run
+	lastID  uint64
+	history []Event // state transitions, oldest first
+	evicted uint64  // highest ID pushed out of history
+	subs    map[*Subscription]struct{}
+}
+
+func newEventBus(start uint64) *eventBus {
+	return &eventBus{start: start, lastID: start, subs: make(map[*Subscription]struct{})}
+}
+
+func (b *eventBus) subscribe(since uint64, match func(Event) bool) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: ch, LastID: b.lastID, bus: b, ch: ch, match: match}
+	if since > 0 {
+		// An ID outside this run's range was handed out before a restart.
+		s.Missed = since < b.start || since < b.evicted || since > b.lastID
+		for _, e := range b.history {
+			if e.ID > since && match(e) {
+				s.Backlog = append(s.Backlog, e)
+			}
+		}
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *eventBus) drop(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+// publish assigns the next ID to e and hands it to the subscribers without
+// blocking. It is called with the task locked in storage, so the events of
+// a task come out in the order its state changed.
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	if e.Type != EventProgress {
+		if len(b.history) == eventHistory {
+			b.evicted = b.history[0].ID
+			b.history = append(b.history[:0], b.history[1:]...)
+		}
+		b.history = append(b.history, e)
+	}
+	for s := range b.subs {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			b.drop(s)
+		}
+	}
+}
+
+// taskState is what publishChanges compares against.
+type taskState struct {
+	status string
+	parts  []string
+}
+
+func stateOf(t *storage.Task) taskState {
+	st := taskState{status: t.Status, parts: make([]string, len(t.Parts))}
+	for i, p := range t.Parts {
+		st.parts[i] = p.Status
+	}
+	return st
+}
+
+// publishChanges emits an event for every part and for the task whose
+// status differs from before.
+func (b *eventBus) publishChanges(t *storage.Task, before taskState) {
+	now := time.Now().Unix()
+	for i := range t.Parts {
+		p := &t.Parts[i]
+		if i < len(before.parts) && before.parts[i] == p.Status {
+			continue
+		}
+		idx := i
+		b.publish(Event{
+			Type: EventPart, TaskID: t.ID, Part: &idx, Status: p.Status,
+			Error: p.Error, ErrorCode: p.ErrorCode,
+			BytesDone: p.BytesDone, BytesTotal: p.BytesTotal, Time: now,
+		})
+	}
+	if t.Status != before.status {
+		b.publish(Event{Type: EventTask, TaskID: t.ID, Status: t.Status, Time: now})
+	}
+}
+
+// publishProgress reports the bytes, speed and ETA of a downloading part;
+// addProgress calls it at most once per progressInterval.
+func (b *eventBus) publishProgress(taskID string, idx int, p *storage.FilePart) {
+	b.publish(Event{
+		Type: EventProgress, TaskID: taskID, Part: &idx, Status: p.Status,
+		BytesDone: p.BytesDone, BytesTotal: p.BytesTotal,
+		Speed: p.Speed, ETA: p.ETA,
+	})
+}
+
+// Subscribe streams the events accepted by match (nil for all of them).
+// With since > 0 the kept state transitions after that event ID are
+// returned in Backlog first. The caller must Close the subscription.
+func (m *Manager) Subscribe(since uint64, match func(Event) bool) *Subscription {
+	if match == nil {
+		match = func(Event) bool { return true }
+	}
+	return m.events.subscribe(since, match)
+}
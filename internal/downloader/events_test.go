@@ -0,0 +1,157 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestEventBusReplaysTransitionsAfterLastID(t *testing.T) {
+	b := newEventBus(0)
+	for _, status := range []string{"running", "paused", "running"} {
+		b.publish(Event{Type: EventTask, TaskID: "t1", Status: status})
+		b.publish(Event{Type: EventTask, TaskID: "t2", Status: status})
+	}
+	b.publish(Event{Type: EventProgress, TaskID: "t1", BytesDone: 10})
+
+	onlyT1 := func(e Event) bool { return e.TaskID == "t1" }
+	sub := b.subscribe(1, onlyT1)
+	defer sub.Close()
+	if sub.Missed || sub.LastID != 7 {
+		t.Fatalf("unexpected subscription state %+v", sub)
+	}
+	// Progress is not replayed, only state transitions are.
+	if len(sub.Backlog) != 2 || sub.Backlog[0].ID != 3 || sub.Backlog[1].ID != 5 {
+		t.Fatalf("unexpected backlog %+v", sub.Backlog)
+	}
+
+	b.publish(Event{Type: EventTask, TaskID: "t2", Status: "done"})
+	b.publish(Event{Type: EventTask, TaskID: "t1", Status: "done"})
+	if e := <-sub.C; e.ID != 9 || e.Status != "done" {
+		t.Fatalf("unexpected live event %+v", e)
+	}
+}
+
+func TestEventBusReportsEvictedHistory(t *testing.T) {
+	b := newEventBus(0)
+	for i := 0; i < eventHistory+10; i++ {
+		b.publish(Event{Type: EventTask, TaskID: "t1", Status: "running"})
+	}
+	all := func(Event) bool { return true }
+	if sub := b.subscribe(5, all); !sub.Missed || len(sub.Backlog) != eventHistory {
+		t.Fatalf("expected missed events, got missed=%v backlog=%d", sub.Missed, len(sub.Backlog))
+	}
+	if sub := b.subscribe(20, all); sub.Missed {
+		t.Fatalf("events after 20 are all kept")
+	}
+}
+
+func TestEventBusReportsIDFromEarlierRun(t *testing.T) {
+	all := func(Event) bool { return true }
+	b := newEventBus(100)
+	b.publish(Event{Type: EventTask, TaskID: "t1", Status: "running"})
+	// The client saw event 5000 before the server restarted.
+	if sub := b.subscribe(5000, all); !sub.Missed {
+		t.Fatalf("an ID above the last one must count as missed, got %+v", sub)
+	}
+	if sub := b.subscribe(101, all); sub.Missed {
+		t.Fatalf("the last ID itself is not missed")
+	}
+	if sub := b.subscribe(100, all); sub.Missed || len(sub.Backlog) != 1 {
+		t.Fatalf("subscribing before the first event replays it, got %+v", sub)
+	}
+
+	// The new run has gone past the ID a client kept from the old one.
+	for i := 0; i < 10; i++ {
+		b.publish(Event{Type: EventTask, TaskID: "t1", Status: "running"})
+	}
+	if sub := b.subscribe(5, all); !sub.Missed {
+		t.Fatalf("an ID from before the restart must count as missed, got %+v", sub)
+	}
+}
+
+func TestManagerEventIDsGrowAcrossRestarts(t *testing.T) {
+	st, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	first := NewManager(st, t.TempDir(), 0)
+	first.events.publish(Event{Type: EventTask, TaskID: "t1", Status: "running"})
+	seen := first.Subscribe(0, nil)
+	seen.Close()
+	time.Sleep(time.Millisecond)
+
+	restarted := NewManager(st, t.TempDir(), 0)
+	sub := restarted.Subscribe(seen.LastID, nil)
+	defer sub.Close()
+	if sub.LastID <= seen.LastID || !sub.Missed {
+		t.Fatalf("IDs of the new run must start above %d and the old one count as missed, got %+v", seen.LastID, sub)
+	}
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	b := newEventBus(0)
+	sub := b.subscribe(0, func(Event) bool { return true })
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.publish(Event{Type: EventTask, TaskID: "t1"})
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("expected %d buffered events before close, got %d", subscriberBuffer, n)
+	}
+	sub.Close() // closing twice is fine
+}
+
+func TestManagerPublishesTaskEvents(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	sub := mgr.Subscribe(0, nil)
+	defer sub.Close()
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/a.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitTaskStatus(t, st, task.ID, "done")
+
+	var seen []string
+	for len(sub.C) > 0 {
+		e := <-sub.C
+		if e.TaskID != task.ID {
+			t.Fatalf("unexpected task in %+v", e)
+		}
+		if e.Type != EventProgress {
+			seen = append(seen, e.Type+":"+e.Status)
+		}
+	}
+	want := []string{"created:running", "part:downloading", "part:done", "task:done"}
+	if len(seen) != len(want) {
+		t.Fatalf("expected %v, got %v", want, seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, seen)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"os"
+	"path"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// OpenFile opens the downloaded file of part idx for reading. Only parts
+// that are done have a file under their final name; others give
+// ErrPartNotDone.
+func (m *Manager) OpenFile(taskID string, idx int) (*os.File, storage.FilePart, error) {
+	task, ok := m.storage.Get(taskID)
+	if !ok {
+		return nil, storage.FilePart{}, ErrTaskNotFound
+	}
+	if idx < 0 || idx >= len(task.Parts) {
+		return nil, storage.FilePart{}, ErrPartNotFound
+	}
+	part := task.Parts[idx]
+	if part.Status != "done" {
+		return nil, part, ErrPartNotDone
+	}
+	f, err := os.Open(m.finalPath(part.FileName))
+	if err != nil {
+		return nil, part, err
+	}
+	return f, part, nil
+}
+
+// PartIndex finds the part of a task by file name: the full name relative
+// to the download directory, or just its last element.
+func (m *Manager) PartIndex(taskID, name string) (int, error) {
+	task, ok := m.storage.Get(taskID)
+	if !ok {
+		return 0, ErrTaskNotFound
+	}
+	for i, p := range task.Parts {
+		if p.FileName == name {
+			return i, nil
+		}
+	}
+	for i, p := range task.Parts {
+		if path.Base(p.FileName) == name {
+			return i, nil
+		}
+	}
+	return 0, ErrPartNotFound
+}
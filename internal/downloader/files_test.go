@@ -0,0 +1,72 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestManagerOpenFile(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	mgr.SetLayout(Layout{dirs: []string{"{task_id}"}})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/a.txt", srv.URL+"/missing"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitTaskStatus(t, st, task.ID, "partial")
+
+	f, part, err := mgr.OpenFile(task.ID, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "payload" || part.Checksum == "" {
+		t.Fatalf("unexpected file %q, part %+v", data, part)
+	}
+
+	if _, _, err := mgr.OpenFile(task.ID, 1); !errors.Is(err, ErrPartNotDone) {
+		t.Fatalf("expected ErrPartNotDone, got %v", err)
+	}
+	if _, _, err := mgr.OpenFile(task.ID, 2); !errors.Is(err, ErrPartNotFound) {
+		t.Fatalf("expected ErrPartNotFound, got %v", err)
+	}
+	if _, _, err := mgr.OpenFile("missing", 0); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	for _, name := range []string{task.ID + "/a.txt", "a.txt"} {
+		if idx, err := mgr.PartIndex(task.ID, name); err != nil || idx != 0 {
+			t.Fatalf("PartIndex(%q) = %d, %v", name, idx, err)
+		}
+	}
+	if _, err := mgr.PartIndex(task.ID, "b.txt"); !errors.Is(err, ErrPartNotFound) {
+		t.Fatalf("expected ErrPartNotFound, got %v", err)
+	}
+}
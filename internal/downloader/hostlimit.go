@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostLimit bounds how hard a single origin is hit. Zero values disable the
+// corresponding limit.
+type HostLimit struct {
+	MaxConns int           // parts downloaded from the host at the same time
+	Delay    time.Duration // minimum gap between two parts starting on the host
+}
+
+// hostLimits is the default limit plus per-domain overrides. An override for
+// "example.com" also covers its subdomains; the longest match wins.
+type hostLimits struct {
+	def       HostLimit
+	overrides map[string]HostLimit
+}
+
+func (hl hostLimits) lookup(host string) HostLimit {
+	best, bestLen := hl.def, -1
+	for domain, l := range hl.overrides {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > bestLen {
+			best, bestLen = l, len(domain)
+		}
+	}
+	return best
+}
+
+// hostOf returns the key parts are grouped by for host limits: the lower-cased
+// host name without the port. Unparsable URLs get an empty key, which is
+// never limited; fetchPart rejects them anyway.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// ParseHostLimits parses per-domain overrides written as a comma separated
+// list of domain=conns[/delay], e.g. "example.com=2/500ms,cdn.example.org=8".
+func ParseHostLimits(v string) (map[string]HostLimit, error) {
+	out := make(map[string]HostLimit)
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		domain, spec, ok := strings.Cut(item, "=")
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("invalid host limit %q: want domain=conns[/delay]", item)
+		}
+		connsStr, delayStr, hasDelay := strings.Cut(strings.TrimSpace(spec), "/")
+		conns, err := strconv.Atoi(strings.TrimSpace(connsStr))
+		if err != nil || conns < 0 {
+			return nil, fmt.Errorf("invalid host limit %q: bad connection count", item)
+		}
+		l := HostLimit{MaxConns: conns}
+		if hasDelay {
+			l.Delay, err = time.ParseDuration(strings.TrimSpace(delayStr))
+			if err != nil || l.Delay < 0 {
+				return nil, fmt.Errorf("invalid host limit %q: bad delay", item)
+			}
+		}
+		out[domain] = l
+	}
+	return out, nil
+}
+
+// SetHostLimits sets the default per-host limit and the per-domain
+// overrides. It can be called at any time; parts already downloading are
+// not interrupted.
+func (m *Manager) SetHostLimits(def HostLimit, overrides map[string]HostLimit) {
+	m.sched.setHostLimits(hostLimits{def: def, overrides: overrides})
+}
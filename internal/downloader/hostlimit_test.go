@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestParseHostLimits(t *testing.T) {
+	got, err := ParseHostLimits(" Example.com=2/500ms, cdn.example.org=8 ,")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got["example.com"] != (HostLimit{MaxConns: 2, Delay: 500 * time.Millisecond}) {
+		t.Fatalf("unexpected example.com limit %+v", got["example.com"])
+	}
+	if got["cdn.example.org"] != (HostLimit{MaxConns: 8}) {
+		t.Fatalf("unexpected cdn.example.org limit %+v", got["cdn.example.org"])
+	}
+	for _, bad := range []string{"example.com", "=2", "example.com=x", "example.com=2/soon", "example.com=-1"} {
+		if _, err := ParseHostLimits(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+
+	hl := hostLimits{def: HostLimit{MaxConns: 4}, overrides: got}
+	cases := map[string]HostLimit{
+		"example.com":         got["example.com"],
+		"dl.example.com":      got["example.com"],
+		"cdn.example.org":     got["cdn.example.org"],
+		"notexample.com":      {MaxConns: 4},
+		"img.cdn.example.org": got["cdn.example.org"],
+	}
+	for host, want := range cases {
+		if l := hl.lookup(host); l != want {
+			t.Fatalf("lookup(%q) = %+v, want %+v", host, l, want)
+		}
+	}
+}
+
+func TestManagerRespectsHostConnectionLimit(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 4)
+	mgr.SetHostLimits(HostLimit{MaxConns: 1}, nil)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	urls := []string{srv.URL + "/1.bin", srv.URL + "/2.bin", srv.URL + "/3.bin", srv.URL + "/4.bin"}
+	task, err := mgr.CreateTask(context.Background(), partSpecs(urls...), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitTaskStatus(t, st, task.ID, "done")
+	if p := peak.Load(); p != 1 {
+		t.Fatalf("expected at most 1 connection to the host, saw %d", p)
+	}
+}
@@ -0,0 +1,125 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultLayout puts every file straight into the download directory.
+const DefaultLayout = "{name}"
+
+// layoutVars are the placeholders a layout may use besides {name}.
+var layoutVars = map[string]bool{"task_id": true, "host": true, "date": true}
+
+// Layout decides where in the download directory the files of a task go.
+// It is a slash separated template whose last element is {name}, e.g.
+// "{task_id}/{name}", "{host}/{date}/{name}". The zero Layout is
+// DefaultLayout.
+type Layout struct {
+	dirs []string
+}
+
+// ParseLayout validates a layout template. Every directory element is
+// either literal text or built from {task_id}, {host} and {date}
+// (YYYY-MM-DD, UTC); it can not climb out of the download directory.
+func ParseLayout(v string) (Layout, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return Layout{}, nil
+	}
+	elems := strings.Split(v, "/")
+	if elems[len(elems)-1] != "{name}" {
+		return Layout{}, fmt.Errorf("invalid layout %q: must end with {name}", v)
+	}
+	dirs := elems[:len(elems)-1]
+	for _, d := range dirs {
+		if err := checkLayoutElem(d); err != nil {
+			return Layout{}, fmt.Errorf("invalid layout %q: %w", v, err)
+		}
+	}
+	return Layout{dirs: dirs}, nil
+}
+
+func checkLayoutElem(elem string) error {
+	switch {
+	case elem == "":
+		return fmt.Errorf("empty path element")
+	case elem == "." || elem == "..":
+		return fmt.Errorf("%q is not allowed", elem)
+	case strings.ContainsAny(elem, "\\\x00"):
+		return fmt.Errorf("%q contains a forbidden character", elem)
+	}
+	rest := elem
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return fmt.Errorf("unclosed placeholder in %q", elem)
+		}
+		name := rest[open+1 : open+end]
+		if !layoutVars[name] {
+			return fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		rest = rest[open+end+1:]
+	}
+	if strings.ContainsRune(rest, '}') {
+		return fmt.Errorf("unbalanced } in %q", elem)
+	}
+	return nil
+}
+
+// String returns the template the layout was parsed from.
+func (l Layout) String() string {
+	if len(l.dirs) == 0 {
+		return DefaultLayout
+	}
+	return strings.Join(l.dirs, "/") + "/{name}"
+}
+
+// dir renders the directory of a file, relative to the download directory.
+// Substituted values are cleaned like server supplied names, so a crafted
+// host can not introduce extra path elements.
+func (l Layout) dir(taskID, rawURL string, created time.Time) string {
+	vals := map[string]string{
+		"task_id": taskID,
+		"host":    hostOf(rawURL),
+		"date":    created.UTC().Format("2006-01-02"),
+	}
+	out := make([]string, 0, len(l.dirs))
+	for _, d := range l.dirs {
+		var b strings.Builder
+		for {
+			open := strings.IndexByte(d, '{')
+			if open < 0 {
+				break
+			}
+			end := open + strings.IndexByte(d[open:], '}')
+			val := cleanFileName(vals[d[open+1:end]])
+			if val == "" {
+				val = "_"
+			}
+			b.WriteString(d[:open])
+			b.WriteString(val)
+			d = d[end+1:]
+		}
+		b.WriteString(d)
+		elem := b.String()
+		if elem == "." || elem == ".." {
+			elem = "_"
+		}
+		out = append(out, elem)
+	}
+	return path.Join(out...)
+}
+
+// SetLayout sets the layout for tasks created without one of their own.
+func (m *Manager) SetLayout(l Layout) {
+	m.mu.Lock()
+	m.layout = l
+	m.mu.Unlock()
+}
@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestParseLayout(t *testing.T) {
+	for _, good := range []string{"", "{name}", "{task_id}/{name}", "{host}/{date}/{name}", "dl-{date}/{name}"} {
+		if _, err := ParseLayout(good); err != nil {
+			t.Fatalf("expected %q to be accepted: %v", good, err)
+		}
+	}
+	for _, bad := range []string{"{task_id}", "{name}/x", "/{name}", "../{name}", "a//{name}", "{user}/{name}", "{host/{name}", "a}/{name}", `a\b/{name}`} {
+		if _, err := ParseLayout(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+	if l, _ := ParseLayout(""); l.String() != DefaultLayout {
+		t.Fatalf("unexpected default layout %q", l.String())
+	}
+}
+
+func TestLayoutDir(t *testing.T) {
+	created := time.Date(2025, 9, 30, 23, 30, 0, 0, time.FixedZone("X", -3600))
+	cases := map[string]string{
+		"{name}":                  "",
+		"{task_id}/{name}":        "t1",
+		"{host}/{date}/{name}":    "example.com/2025-10-01",
+		"by-host/{host}-x/{name}": "by-host/example.com-x",
+	}
+	for tmpl, want := range cases {
+		l, err := ParseLayout(tmpl)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tmpl, err)
+		}
+		if got := l.dir("t1", "https://Example.com:8443/a.bin", created); got != want {
+			t.Fatalf("%q rendered %q, want %q", tmpl, got, want)
+		}
+	}
+
+	// Substituted values never add path elements or climb up.
+	l, _ := ParseLayout("{task_id}/{name}")
+	for _, id := range []string{"../..", "a/b", ".."} {
+		if got := l.dir(id, "https://example.com/a", created); strings.Contains(got, "/") || got == ".." {
+			t.Fatalf("task id %q rendered unsafe dir %q", id, got)
+		}
+	}
+}
+
+func TestManagerCreateTaskAppliesLayout(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	mgr := NewManager(st, tmp, 0)
+	defer mgr.Shutdown()
+	def, _ := ParseLayout("{host}/{name}")
+	mgr.SetLayout(def)
+
+	task, err := mgr.CreateTask(context.Background(), []PartSpec{
+		{URL: "https://example.com/a.bin"},
+		{URL: "https://example.com/a.bin", Subdir: "isos"},
+	}, TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if task.Parts[0].FileName != "example.com/a.bin" || !strings.HasPrefix(task.Parts[1].FileName, "example.com/isos/") {
+		t.Fatalf("unexpected names %q, %q", task.Parts[0].FileName, task.Parts[1].FileName)
+	}
+
+	// Per-task layouts override the default; the same name in another
+	// directory is not a collision.
+	other, err := mgr.CreateTask(context.Background(), partSpecs("https://example.com/a.bin"), TaskOptions{Layout: "{task_id}/{name}"})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if want := other.ID + "/a.bin"; other.Parts[0].FileName != want {
+		t.Fatalf("expected %q, got %q", want, other.Parts[0].FileName)
+	}
+
+	if _, err := mgr.CreateTask(context.Background(), partSpecs("https://example.com/b.bin"), TaskOptions{Layout: "../{name}"}); err == nil {
+		t.Fatalf("expected invalid layout to be rejected")
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,46 +21,195 @@ import (
 	"test-task-30-09-2025/internal/storage"
 )
 
+const (
+	// defaultMinSegmentSize keeps tiny files on a single connection.
+	defaultMinSegmentSize = 1 << 20
+	// checkpointInterval bounds how often progress is flushed to disk.
+	checkpointInterval = time.Second
+)
+
 type Manager struct {
-	storage     *storage.FileStorage
-	downloadDir string
-	workers     int
+	storage        *storage.FileStorage
+	downloadDir    string
+	workers        int
+	segments       int
+	minSegmentSize int64
+	retry          RetryPolicy
+	queueLimit     int
+	globalLimit    *limiter
+	quarantineDir  string
+	layout         Layout
 
-	mu        sync.Mutex
-	wg        sync.WaitGroup
-	closing   bool
-	jobCh     chan *storage.Task
-	usedNames map[string]struct{}
+	admitMu    sync.Mutex
+	mu         sync.Mutex
+	wg         sync.WaitGroup
+	sched      *scheduler
+	usedNames  map[string]struct{}
+	active     map[string]*activeTask
+	taskLimits map[string]*limiter
+	events     *eventBus
+	rates      *rateMeter
+	hooks      *outbox
+	hookRetry  RetryPolicy
+	hookCancel context.CancelFunc
 }
 
 func NewManager(st *storage.FileStorage, downloadDir string, workers int) *Manager {
-	return &Manager{
-		storage:     st,
-		downloadDir: downloadDir,
-		workers:     workers,
-		jobCh:       make(chan *storage.Task, 256),
-		usedNames:   make(map[string]struct{}),
+	m := &Manager{
+		storage:       st,
+		downloadDir:   downloadDir,
+		quarantineDir: filepath.Join(downloadDir, ".quarantine"),
+		workers:       workers,
+		segments:      1,
+
+		minSegmentSize: defaultMinSegmentSize,
+		retry:          DefaultRetryPolicy(),
+		sched:          newScheduler(),
+		usedNames:      make(map[string]struct{}),
+		active:         make(map[string]*activeTask),
+		globalLimit:    newLimiter(0),
+		taskLimits:     make(map[string]*limiter),
+		events:         newEventBus(uint64(time.Now().UnixMicro())),
+		rates:          newRateMeter(),
+		hooks:          newOutbox(),
+		hookRetry:      DefaultWebhookRetry(),
+	}
+	m.sched.setBreakerPolicy(DefaultBreakerPolicy())
+	return m
+}
+
+// SetSegments sets how many byte ranges a single file is split into when the
+// origin supports Range requests. Values below 2 disable segmentation.
+// It must be called before RestoreFromStorage starts the workers.
+func (m *Manager) SetSegments(n int) {
+	if n < 1 {
+		n = 1
+	}
+	m.segments = n
+}
+
+// SetRetryPolicy replaces the default retry policy. It must be called before
+// RestoreFromStorage starts the workers.
+func (m *Manager) SetRetryPolicy(p RetryPolicy) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	m.retry = p
+}
+
+// SetQueueLimit caps the number of parts waiting in the queue. CreateTask
+// fails with ErrQueueFull instead of accepting more, or with ErrTaskTooLarge
+// for a task that would not fit even into an empty queue. Zero means no
+// limit.
+func (m *Manager) SetQueueLimit(n int) {
+	if n < 0 {
+		n = 0
 	}
+	m.queueLimit = n
+}
+
+// QueueStats is a snapshot of the scheduler load.
+type QueueStats struct {
+	Queued  int `json:"queued"`  // parts ready to start
+	Delayed int `json:"delayed"` // parts waiting for a retry
+	Active  int `json:"active"`  // parts being downloaded right now
+	Limit   int `json:"limit"`   // 0 means unlimited
+	Workers int `json:"workers"`
+}
+
+func (m *Manager) QueueStats() QueueStats {
+	ready, delayed := m.sched.depth()
+	m.mu.Lock()
+	active := 0
+	for _, at := range m.active {
+		active += at.parts
+	}
+	m.mu.Unlock()
+	return QueueStats{
+		Queued:  ready,
+		Delayed: delayed,
+		Active:  active,
+		Limit:   m.queueLimit,
+		Workers: m.workers,
+	}
+}
+
+// TaskOptions are the per-task settings accepted by CreateTask.
+type TaskOptions struct {
+	Priority  int
+	RateLimit int64  // bytes per second, 0 for unlimited
+	Layout    string // see ParseLayout, "" for the manager default
+	// Callback is notified when the task finishes, with the payload signed
+	// by CallbackSecret if one is given.
+	Callback       string
+	CallbackSecret string
 }
 
 func (m *Manager) RestoreFromStorage() error {
-	// Enqueue tasks that are not done
-	for _, t := range m.storage.List() {
+	// Enqueue tasks that are not done, in the order they would have run
+	tasks := m.storage.List()
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return a.ID < b.ID
+	})
+	owned := make(map[string]bool)
+	var settle []string
+	for _, t := range tasks {
 		for i := range t.Parts {
 			m.reserveFileName(t.Parts[i].FileName)
+			if t.Parts[i].Status != "done" {
+				m.adoptInPlace(t.Parts[i])
+				owned[m.stagingPath(t.Parts[i].FileName)] = true
+			} else {
+				// Names from older versions may look like staging files.
+				owned[m.finalPath(t.Parts[i].FileName)] = true
+			}
+		}
+		if t.Status == "done" || t.Status == "partial" || t.Status == "error" {
+			// Finished while the notification was not queued yet.
+			if t.CallbackStatus == "" {
+				m.queueWebhook(t)
+			}
 		}
-		if t.Status == "done" {
+		if t.Status == "done" || t.Status == "cancelled" || t.Status == "paused" {
 			continue
 		}
-		// Reset transient states to pending
+		// Reset transient states to pending, failed parts get another try.
+		// Parts waiting for a retry keep their schedule.
 		for i := range t.Parts {
-			if t.Parts[i].Status == "downloading" {
+			if t.Parts[i].Status == "downloading" || t.Parts[i].Status == "error" {
 				t.Parts[i].Status = "pending"
 			}
 		}
+		if !hasWork(t) {
+			// Nothing to download again, e.g. only verify_failed parts are
+			// left: a finished task stays as it is, one that stopped before
+			// its status was derived gets it now.
+			if !finalStatus(t.Status) {
+				settle = append(settle, t.ID)
+			}
+			continue
+		}
 		t.Status = "running"
-		m.enqueue(t)
+		t.FinishedAt, t.Duration = 0, 0
+		clearRates(t)
+		m.storage.Put(t)
+		m.enqueueTask(t)
+	}
+	for _, id := range settle {
+		m.refreshStatus(id)
 	}
+	m.sweepStaging(owned)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.hookCancel = cancel
+	m.wg.Add(1)
+	go m.deliverWebhooks(ctx)
 	// Start workers
 	for i := 0; i < m.workers; i++ {
 		m.wg.Add(1)
@@ -65,100 +218,420 @@ func (m *Manager) RestoreFromStorage() error {
 	return nil
 }
 
+// Shutdown stops handing out work and waits for the parts in progress.
 func (m *Manager) Shutdown() {
-	m.mu.Lock()
-	m.closing = true
-	close(m.jobCh)
-	m.mu.Unlock()
+	m.sched.close()
+	if m.hookCancel != nil {
+		m.hookCancel()
+	}
 	m.wg.Wait()
 }
 
-func (m *Manager) CreateTask(ctx context.Context, urls []string) (*storage.Task, error) {
-	if len(urls) == 0 {
+func (m *Manager) CreateTask(ctx context.Context, specs []PartSpec, opts TaskOptions) (*storage.Task, error) {
+	if len(specs) == 0 {
 		return nil, errors.New("empty urls")
 	}
+	specs = append([]PartSpec(nil), specs...)
+	for i := range specs {
+		if err := specs[i].Normalize(); err != nil {
+			return nil, fmt.Errorf("urls[%d]: %w", i, err)
+		}
+	}
+	if opts.Callback != "" {
+		if err := CheckCallbackURL(opts.Callback); err != nil {
+			return nil, fmt.Errorf("callback: %w", err)
+		}
+	}
+	m.mu.Lock()
+	layout := m.layout
+	m.mu.Unlock()
+	if opts.Layout != "" {
+		var err error
+		if layout, err = ParseLayout(opts.Layout); err != nil {
+			return nil, err
+		}
+	}
+	// Check and enqueue atomically so concurrent requests cannot overshoot.
+	m.admitMu.Lock()
+	defer m.admitMu.Unlock()
+	if m.queueLimit > 0 && len(specs) > m.queueLimit {
+		return nil, fmt.Errorf("%w: %d files, limit %d", ErrTaskTooLarge, len(specs), m.queueLimit)
+	}
+	if m.queueLimit > 0 && m.sched.len()+len(specs) > m.queueLimit {
+		return nil, ErrQueueFull
+	}
+
 	id := randomID()
-	parts := make([]storage.FilePart, 0, len(urls))
-	for _, u := range urls {
-		baseName := safeFileName(u)
-		uniqueName := m.uniqueFileName(baseName)
+	created := time.Now()
+	parts := make([]storage.FilePart, 0, len(specs))
+	for _, spec := range specs {
+		baseName := spec.FileName
+		if baseName == "" {
+			baseName = safeFileName(spec.URL)
+		}
+		dir := path.Join(layout.dir(id, spec.URL, created), spec.Subdir)
+		uniqueName := m.uniqueFileName(dir, baseName)
 		parts = append(parts, storage.FilePart{
-			URL:        u,
-			FileName:   uniqueName,
-			BytesTotal: 0,
-			BytesDone:  0,
-			Status:     "pending",
+			URL:              spec.URL,
+			FileName:         uniqueName,
+			BytesTotal:       0,
+			BytesDone:        0,
+			Status:           "pending",
+			NameFinal:        spec.FileName != "",
+			ExpectedSize:     spec.Size,
+			ExpectedChecksum: spec.Checksum,
+			Headers:          spec.Headers,
+			Mirrors:          spec.Mirrors,
 		})
 	}
-	task := &storage.Task{ID: id, CreatedAt: time.Now().Unix(), Status: "running", Parts: parts}
+	task := &storage.Task{
+		ID:        id,
+		CreatedAt: created.Unix(),
+		Status:    "running",
+		Priority:  opts.Priority,
+		RateLimit: opts.RateLimit,
+		Parts:     parts,
+		Callback:  opts.Callback,
+	}
+	if opts.CallbackSecret != "" {
+		if err := m.hooks.setSecret(id, opts.CallbackSecret); err != nil {
+			for _, p := range parts {
+				m.releaseFileName(p.FileName)
+			}
+			return nil, err
+		}
+	}
 	m.storage.Put(task)
-	m.enqueue(task)
+	m.events.publish(Event{Type: EventCreated, TaskID: id, Status: task.Status, Time: task.CreatedAt})
+	m.enqueueTask(task)
 	return task, nil
 }
 
-func (m *Manager) enqueue(task *storage.Task) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if m.closing {
-		return
+// hasWork reports whether enqueueTask would queue any part of the task.
+func hasWork(task *storage.Task) bool {
+	for _, p := range task.Parts {
+		if !partFinished(p.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueTask schedules every unfinished part of the task. Parts waiting
+// for a retry are held back until their NextRetryAt.
+func (m *Manager) enqueueTask(task *storage.Task) {
+	for i, p := range task.Parts {
+		if partFinished(p.Status) {
+			continue
+		}
+		j := job{taskID: task.ID, part: i, host: hostOf(sourceURL(p))}
+		m.sched.pushAt(j, task.Priority, time.Unix(p.NextRetryAt, 0))
 	}
-	m.jobCh <- task
 }
 
 func (m *Manager) worker() {
 	defer m.wg.Done()
 	client := &http.Client{Timeout: 0}
-	for task := range m.jobCh {
-		m.processTask(client, task)
+	for {
+		j, ok := m.sched.next()
+		if !ok {
+			return
+		}
+		m.processPart(client, j)
+		m.sched.release(j)
 	}
 }
 
-func (m *Manager) processTask(client *http.Client, task *storage.Task) {
-	allOK := true
-	partial := false
-	for i := range task.Parts {
-		p := &task.Parts[i]
-		if p.Status == "done" {
-			continue
+func (m *Manager) processPart(client *http.Client, j job) {
+	ctx, task, done := m.begin(j.taskID)
+	if task == nil {
+		return
+	}
+	defer done()
+
+	p := task.Parts[j.part]
+	if partFinished(p.Status) {
+		return
+	}
+
+	err := m.downloadPart(ctx, client, j.taskID, j.part)
+	switch {
+	case err == nil:
+		m.sched.report(j.host, false, time.Now())
+		m.updatePart(j.taskID, j.part, func(p *storage.FilePart) {
+			p.Status = "done"
+			setPartError(p, nil)
+			p.NextRetryAt = 0
+		})
+	case ctx.Err() != nil:
+		// Stopped from outside, whoever stopped the task does the bookkeeping.
+		return
+	case errors.Is(err, errVerifyFailed):
+		m.sched.report(j.host, false, time.Now())
+		m.updatePart(j.taskID, j.part, func(p *storage.FilePart) {
+			p.Status = "verify_failed"
+			setPartError(p, err)
+			p.NextRetryAt = 0
+		})
+		m.quarantine(j.taskID, j.part)
+	default:
+		// Once the host's breaker is open the retry waits out the cooldown,
+		// and the host's other parts are held back without spending attempts.
+		until, opened := m.sched.report(j.host, hostFailure(err), time.Now())
+		if opened {
+			m.deferHost(j.host, until)
+		}
+		attempt := p.Attempts + 1
+		if delay, ok := m.retry.delay(err, attempt); ok {
+			at := time.Now().Add(delay)
+			if until.After(at) {
+				at = until
+			}
+			m.updatePart(j.taskID, j.part, func(p *storage.FilePart) {
+				p.Status = "pending"
+				setPartError(p, err)
+				p.Attempts = attempt
+				p.NextRetryAt = at.Unix()
+			})
+			// The next attempt may go to a mirror on another host.
+			next := p
+			next.Attempts = attempt
+			j.host = hostOf(sourceURL(next))
+			m.sched.pushAt(j, task.Priority, at)
+			return
 		}
-		if err := m.downloadPart(client, p); err != nil {
+		m.updatePart(j.taskID, j.part, func(p *storage.FilePart) {
 			p.Status = "error"
-			p.Error = err.Error()
-			allOK = false
-			partial = true
-			m.storage.Put(task)
-			continue
+			setPartError(p, err)
+			p.Attempts = attempt
+			p.NextRetryAt = 0
+		})
+	}
+	m.refreshStatus(j.taskID)
+}
+
+// refreshStatus derives the task status from its parts once none of them is
+// left to download. Paused and cancelled tasks keep their status.
+func (m *Manager) refreshStatus(taskID string) {
+	finished := false
+	m.update(taskID, func(t *storage.Task) {
+		if t.Status == "cancelled" || t.Status == "paused" {
+			return
+		}
+		allOK := true
+		for _, p := range t.Parts {
+			switch p.Status {
+			case "done":
+			case "error", "verify_failed":
+				allOK = false
+			default:
+				// Still queued, downloading or waiting for a retry.
+				return
+			}
+		}
+		prev := t.Status
+		if allOK {
+			t.Status = "done"
+		} else {
+			t.Status = "partial"
+		}
+		// Workers finishing the last parts together must not both
+		// notify.
+		finished = t.Status != prev
+	})
+	if finished {
+		m.dropLimiter(taskID)
+		if task, ok := m.storage.Get(taskID); ok {
+			m.queueWebhook(task)
 		}
-		p.Status = "done"
-		p.Error = ""
-		m.storage.Put(task)
 	}
-	if allOK {
-		task.Status = "done"
-	} else if partial {
-		task.Status = "partial"
-	} else {
-		task.Status = "error"
+}
+
+// setPartError records err (or clears the error when nil) on the part.
+func setPartError(p *storage.FilePart, err error) {
+	de := classify(err)
+	if de == nil {
+		p.Error, p.ErrorCode, p.Retryable, p.HTTPStatus = "", "", false, 0
+		return
 	}
-	m.storage.Put(task)
+	p.Error = de.Error()
+	p.ErrorCode = string(de.Code)
+	p.Retryable = de.Retryable
+	p.HTTPStatus = de.HTTPStatus
 }
 
-func (m *Manager) downloadPart(client *http.Client, part *storage.FilePart) error {
-	dstPath := filepath.Join(m.downloadDir, part.FileName)
-	// Try resume
-	var start int64 = 0
-	if fi, err := os.Stat(dstPath); err == nil {
-		start = fi.Size()
+// mutate applies fn to the stored task and publishes the status changes it
+// made. It reports whether the task exists.
+func (m *Manager) mutate(taskID string, fn func(t *storage.Task)) bool {
+	return m.storage.Update(taskID, func(t *storage.Task) {
+		before := stateOf(t)
+		fn(t)
+		m.trackTimes(t, before, time.Now())
+		m.events.publishChanges(t, before)
+	})
+}
+
+// update is mutate that also persists the result.
+func (m *Manager) update(taskID string, fn func(t *storage.Task)) {
+	m.mutate(taskID, fn)
+	_ = m.storage.Flush()
+}
+
+// updatePart is update narrowed to a single part.
+func (m *Manager) updatePart(taskID string, idx int, fn func(p *storage.FilePart)) {
+	m.update(taskID, func(t *storage.Task) { fn(&t.Parts[idx]) })
+}
+
+// addProgress records n freshly written bytes of a part without flushing;
+// copyBody checkpoints periodically.
+func (m *Manager) addProgress(taskID string, idx int, n int64) {
+	m.storage.Update(taskID, func(t *storage.Task) {
+		p := &t.Parts[idx]
+		p.BytesDone += n
+		speed, ok := m.rates.sample(partKey{taskID, idx}, p.BytesDone, time.Now())
+		if !ok {
+			return
+		}
+		p.Speed, p.ETA = speed, eta(p.BytesTotal-p.BytesDone, speed)
+		sumRate(t)
+		m.events.publishProgress(taskID, idx, p)
+	})
+}
+
+// errRemoteChanged means the bytes already on disk belong to a different
+// version of the remote file, so the part has to start over from zero.
+var errRemoteChanged = errors.New("remote file changed since last attempt")
+
+// downloadPart fetches one part and returns a classified *Error on failure.
+func (m *Manager) downloadPart(ctx context.Context, client *http.Client, taskID string, idx int) error {
+	err := m.fetchPart(ctx, client, taskID, idx)
+	if errors.Is(err, errRemoteChanged) {
+		if err := m.resetPart(taskID, idx); err != nil {
+			return classify(err)
+		}
+		err = m.fetchPart(ctx, client, taskID, idx)
+	}
+	if err != nil {
+		return classify(err)
+	}
+	return nil
+}
+
+// commitSegment moves a segment forward by n bytes that are synced to disk.
+// A resumed segment trusts Done, so it must never run ahead of the file.
+func (m *Manager) commitSegment(taskID string, idx, seg int, n int64) {
+	m.storage.Update(taskID, func(t *storage.Task) {
+		t.Parts[idx].Segments[seg].Done += n
+	})
+}
+
+func (m *Manager) fetchPart(ctx context.Context, client *http.Client, taskID string, idx int) error {
+	task, ok := m.storage.Get(taskID)
+	if !ok {
+		return fmt.Errorf("task %s not found", taskID)
+	}
+	part := task.Parts[idx]
+	part.URL = sourceURL(part)
+	dstPath := m.stagingPath(part.FileName)
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
+		return err
+	}
+	if u, err := url.Parse(part.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return policyError("unsupported URL %q: only http and https are allowed", part.URL)
+	}
+
+	lim := m.limitersFor(task)
+	// A part holds a single connection slot of its host, so with a host
+	// connection limit in place its segments are fetched one at a time.
+	parallel := 0
+	if m.sched.hostLimit(hostOf(part.URL)).MaxConns > 0 {
+		parallel = 1
+	}
+
+	if len(part.Segments) == 0 && m.segments > 1 && parallel == 0 && fileSize(dstPath) == 0 {
+		if segs, total, hdr := m.planSegments(ctx, client, part); len(segs) > 0 {
+			var err error
+			if dstPath, err = m.resolveName(taskID, idx, part, hdr); err != nil {
+				return err
+			}
+			part.Segments = segs
+			part.BytesTotal = total
+			part.BytesDone = 0
+			part.ETag, part.LastModified = hdr.Get("ETag"), hdr.Get("Last-Modified")
+			m.updatePart(taskID, idx, func(p *storage.FilePart) {
+				p.Segments = segs
+				p.BytesTotal = total
+				p.BytesDone = 0
+				p.ETag, p.LastModified = part.ETag, part.LastModified
+			})
+		}
+	}
+	algo := checksumAlgo(part)
+	h := newHash(algo)
+	if len(part.Segments) > 0 {
+		// Segments arrive out of order, the file is hashed once complete.
+		err := m.downloadSegments(ctx, client, lim, parallel, taskID, idx, part, dstPath)
+		if err == nil {
+			err = hashFile(dstPath, -1, h)
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := m.downloadStream(ctx, client, lim, h, taskID, idx, part, dstPath); err != nil {
+		return err
 	}
+	// The first response may have renamed the file.
+	name := part.FileName
+	if t, ok := m.storage.Get(taskID); ok {
+		name = t.Parts[idx].FileName
+	}
+	if err := m.verifyPart(taskID, idx, part, m.stagingPath(name), formatChecksum(algo, h)); err != nil {
+		return err
+	}
+	return m.finalize(name)
+}
+
+// resetPart throws away everything downloaded for the part so far.
+func (m *Manager) resetPart(taskID string, idx int) error {
+	task, ok := m.storage.Get(taskID)
+	if !ok {
+		return fmt.Errorf("task %s not found", taskID)
+	}
+	dstPath := m.stagingPath(task.Parts[idx].FileName)
+	if err := os.Truncate(dstPath, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	m.updatePart(taskID, idx, func(p *storage.FilePart) {
+		p.Segments = nil
+		p.BytesDone = 0
+		p.BytesTotal = 0
+		p.ETag = ""
+		p.LastModified = ""
+		p.Checksum = ""
+	})
+	return nil
+}
+
+// downloadStream fetches the part over a single connection, resuming from
+// the size of the file already on disk. Resumes carry If-Range, so a changed
+// remote file (or a server ignoring Range) answers 200 and the file is
+// rewritten from zero instead of being spliced. Every byte of the file ends
+// up in h: the part already on disk is re-read before a resume.
+func (m *Manager) downloadStream(ctx context.Context, client *http.Client, lim limiters, h hash.Hash, taskID string, idx int, part storage.FilePart, dstPath string) error {
+	// Try resume
+	start := fileSize(dstPath)
 
-	req, err := http.NewRequest(http.MethodGet, part.URL, nil)
+	req, err := newRequest(ctx, http.MethodGet, part)
 	if err != nil {
 		return err
 	}
 	if start > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+		if v := ifRangeValue(part); v != "" {
+			req.Header.Set("If-Range", v)
+		}
 	}
-	part.Status = "downloading"
+	m.updatePart(taskID, idx, func(p *storage.FilePart) { p.Status = "downloading" })
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -166,53 +639,338 @@ func (m *Manager) downloadPart(client *http.Client, part *storage.FilePart) erro
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("unexpected status: %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Full body: either a fresh download or the resume was refused.
+		start = 0
+	case http.StatusPartialContent:
+		if validatorChanged(part, resp.Header) {
+			return errRemoteChanged
+		}
+	default:
+		return newHTTPStatusError(resp)
 	}
 
 	// Determine total size
-	if resp.ContentLength > 0 {
-		if start > 0 {
-			part.BytesTotal = start + resp.ContentLength
-		} else {
-			part.BytesTotal = resp.ContentLength
+	total, err := expectedTotal(resp, start)
+	if err != nil {
+		return err
+	}
+	if start > 0 {
+		if err := hashFile(dstPath, start, h); err != nil {
+			return err
 		}
+	} else if dstPath, err = m.resolveName(taskID, idx, part, resp.Header); err != nil {
+		return err
 	}
 
-	// Open file
+	// Open file, dropping stale bytes when starting over
+	flags := os.O_CREATE | os.O_WRONLY
+	if start == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(dstPath, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m.updatePart(taskID, idx, func(p *storage.FilePart) {
+		p.BytesTotal = total
+		p.BytesDone = start
+		p.ETag = resp.Header.Get("ETag")
+		p.LastModified = resp.Header.Get("Last-Modified")
+	})
+
+	written, err := m.copyBody(ctx, lim, f, io.TeeReader(resp.Body, h), start, func(n int64) {
+		m.addProgress(taskID, idx, n)
+	}, nil)
+	// Sync to disk for durability, a short body is kept for the next attempt
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	if err == nil && total > 0 && start+written < total {
+		err = io.ErrUnexpectedEOF
+	}
+	return incompleteErr(err, start+written, total)
+}
+
+// errIncomplete marks a body that ended before the announced length. It is
+// retryable: the received bytes stay on disk and the next attempt resumes.
+var errIncomplete = errors.New("incomplete download")
+
+// incompleteErr turns a short read into an errIncomplete with the byte
+// counters, other errors are returned unchanged.
+func incompleteErr(err error, got, want int64) error {
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if want > 0 {
+		return fmt.Errorf("%w: got %d of %d bytes", errIncomplete, got, want)
+	}
+	return fmt.Errorf("%w: connection closed after %d bytes", errIncomplete, got)
+}
+
+// expectedTotal returns the full size of the remote file as announced by the
+// response, or 0 if it is unknown. On 206 the Content-Range total wins and its
+// first byte must match the offset we asked for.
+func expectedTotal(resp *http.Response, start int64) (int64, error) {
+	if resp.StatusCode == http.StatusPartialContent {
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			first, total, err := parseContentRange(cr)
+			if err != nil {
+				return 0, err
+			}
+			if first != start {
+				return 0, validationError("unexpected Content-Range %q for offset %d", cr, start)
+			}
+			if total > 0 {
+				return total, nil
+			}
+		}
+	}
+	if resp.ContentLength > 0 {
+		return start + resp.ContentLength, nil
+	}
+	return 0, nil
+}
+
+// parseContentRange parses "bytes first-last/total". total is -1 when the
+// server reports it as "*".
+func parseContentRange(v string) (first, total int64, err error) {
+	var last int64
+	spec, size, ok := strings.Cut(strings.TrimPrefix(v, "bytes "), "/")
+	if !ok {
+		return 0, 0, validationError("malformed Content-Range %q", v)
+	}
+	if _, err := fmt.Sscanf(spec, "%d-%d", &first, &last); err != nil {
+		return 0, 0, validationError("malformed Content-Range %q", v)
+	}
+	if size == "*" {
+		return first, -1, nil
+	}
+	if _, err := fmt.Sscanf(size, "%d", &total); err != nil {
+		return 0, 0, validationError("malformed Content-Range %q", v)
+	}
+	return first, total, nil
+}
+
+// ifRangeValue picks the validator to send with If-Range. Weak ETags are not
+// allowed there, Last-Modified is used instead.
+func ifRangeValue(part storage.FilePart) string {
+	if part.ETag != "" && !strings.HasPrefix(part.ETag, "W/") {
+		return part.ETag
+	}
+	return part.LastModified
+}
+
+// validatorChanged reports whether a response belongs to a different version
+// of the file than the one recorded on the part.
+func validatorChanged(part storage.FilePart, h http.Header) bool {
+	if etag := h.Get("ETag"); part.ETag != "" && etag != "" {
+		return etag != part.ETag
+	}
+	if lm := h.Get("Last-Modified"); part.LastModified != "" && lm != "" {
+		return lm != part.LastModified
+	}
+	return false
+}
+
+// planSegments asks the origin for the file size and Range support and
+// splits the file into byte ranges. It returns nil when the server does not
+// advertise Accept-Ranges or the file is too small to be worth splitting.
+// The probe headers are returned so the validators can be recorded.
+func (m *Manager) planSegments(ctx context.Context, client *http.Client, part storage.FilePart) ([]storage.Segment, int64, http.Header) {
+	req, err := newRequest(ctx, http.MethodHead, part)
+	if err != nil {
+		return nil, 0, nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return nil, 0, nil
+	}
+	total := resp.ContentLength
+	n := int64(m.segments)
+	if limit := total / m.minSegmentSize; limit < n {
+		n = limit
+	}
+	if n < 2 {
+		return nil, 0, nil
+	}
+
+	size := total / n
+	segs := make([]storage.Segment, n)
+	for i := range segs {
+		segs[i].Start = int64(i) * size
+		segs[i].End = segs[i].Start + size - 1
+	}
+	segs[n-1].End = total - 1
+	return segs, total, resp.Header
+}
+
+// downloadSegments fetches all unfinished segments concurrently into the same
+// file, at most parallel at a time (0 means all of them). The first failing
+// segment aborts the others; progress made so far is kept in storage and
+// picked up on the next attempt.
+func (m *Manager) downloadSegments(ctx context.Context, client *http.Client, lim limiters, parallel int, taskID string, idx int, part storage.FilePart, dstPath string) error {
 	f, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if start > 0 {
-		if _, err := f.Seek(start, 0); err != nil {
+	m.updatePart(taskID, idx, func(p *storage.FilePart) {
+		p.Status = "downloading"
+		// Bytes written after the last commit of a segment are fetched again.
+		p.BytesDone = 0
+		for _, seg := range p.Segments {
+			p.BytesDone += seg.Done
+		}
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if parallel <= 0 {
+		parallel = len(part.Segments)
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(part.Segments))
+	for s, seg := range part.Segments {
+		if seg.Complete() {
+			continue
+		}
+		wg.Add(1)
+		go func(s int, seg storage.Segment) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			}
+			if err := m.fetchSegment(ctx, client, lim, taskID, idx, s, seg, part, f); err != nil {
+				errCh <- err
+				cancel()
+			}
+		}(s, seg)
+	}
+	wg.Wait()
+	close(errCh)
+	// Segments sync what they commit; this makes the whole file durable
+	// before it is hashed and renamed.
+	serr := f.Sync()
+	if err := <-errCh; err != nil {
+		return err
+	}
+	return serr
+}
+
+func (m *Manager) fetchSegment(ctx context.Context, client *http.Client, lim limiters, taskID string, idx, s int, seg storage.Segment, part storage.FilePart, f *os.File) error {
+	offset := seg.Start + seg.Done
+	req, err := newRequest(ctx, http.MethodGet, part)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, seg.End))
+	if v := ifRangeValue(part); v != "" {
+		req.Header.Set("If-Range", v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// If-Range did not match (or Range is no longer honored): the other
+		// segments on disk are from an older version of the file.
+		return errRemoteChanged
+	case resp.StatusCode != http.StatusPartialContent:
+		return fmt.Errorf("segment %d: %w", s, newHTTPStatusError(resp))
+	case validatorChanged(part, resp.Header):
+		return errRemoteChanged
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		first, _, err := parseContentRange(cr)
+		if err != nil {
 			return err
 		}
-		part.BytesDone = start
+		if first != offset {
+			return validationError("segment %d: unexpected Content-Range %q for offset %d", s, cr, offset)
+		}
 	}
 
+	want := seg.End - offset + 1
+	written, err := m.copyBody(ctx, lim, f, io.LimitReader(resp.Body, want), offset, func(n int64) {
+		m.addProgress(taskID, idx, n)
+	}, func(n int64) {
+		m.commitSegment(taskID, idx, s, n)
+	})
+	if err == nil && written < want {
+		err = io.ErrUnexpectedEOF
+	}
+	return incompleteErr(err, seg.Done+written, seg.Len())
+}
+
+// copyBody streams src into f starting at offset, throttled by lim, and
+// reports every written chunk to progress. State is flushed at most once
+// per checkpointInterval. With commit, f is synced before each flush and on
+// return, and commit gets the bytes synced since the last call, so progress
+// that a resume relies on never covers bytes lost in a crash.
+func (m *Manager) copyBody(ctx context.Context, lim limiters, f *os.File, src io.Reader, offset int64, progress, commit func(n int64)) (written int64, err error) {
+	var pending int64 // written but not committed yet
+	syncPending := func() error {
+		if commit == nil || pending == 0 {
+			return nil
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		commit(pending)
+		pending = 0
+		return nil
+	}
+	defer func() {
+		if serr := syncPending(); err == nil {
+			err = serr
+		}
+	}()
 	buf := make([]byte, 128*1024)
+	lastFlush := time.Now()
 	for {
-		n, rerr := resp.Body.Read(buf)
+		n, rerr := src.Read(buf)
 		if n > 0 {
-			if _, werr := f.Write(buf[:n]); werr != nil {
-				return werr
+			if _, werr := f.WriteAt(buf[:n], offset+written); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+			pending += int64(n)
+			progress(int64(n))
+			if err := lim.wait(ctx, n); err != nil {
+				return written, err
+			}
+			if time.Since(lastFlush) >= checkpointInterval {
+				if err := syncPending(); err != nil {
+					return written, err
+				}
+				_ = m.storage.Flush()
+				lastFlush = time.Now()
 			}
-			part.BytesDone += int64(n)
 		}
 		if rerr == io.EOF {
-			break
+			return written, nil
 		}
 		if rerr != nil {
-			return rerr
+			return written, rerr
 		}
 	}
-	// Sync to disk for durability
-	if err := f.Sync(); err != nil {
-		return err
-	}
-	return nil
 }
 
 func randomID() string {
@@ -221,22 +979,9 @@ func randomID() string {
 	return hex.EncodeToString(b[:])
 }
 
-func safeFileName(u string) string {
-	// naive: take last path segment, strip query
-	s := u
-	if idx := strings.Index(s, "?"); idx >= 0 {
-		s = s[:idx]
-	}
-	if idx := strings.LastIndex(s, "/"); idx >= 0 {
-		s = s[idx+1:]
-	}
-	if s == "" {
-		s = randomID()
-	}
-	return s
-}
-
-func (m *Manager) uniqueFileName(base string) string {
+// uniqueFileName returns a name for base inside dir (relative to the
+// download directory, may be empty) that no other part uses.
+func (m *Manager) uniqueFileName(dir, base string) string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -253,14 +998,14 @@ func (m *Manager) uniqueFileName(base string) string {
 	}
 
 	for attempt := 0; attempt < 1000; attempt++ {
-		name := base
+		name := path.Join(dir, base)
 		if attempt > 0 {
-			name = fmt.Sprintf("%s-%s%s", stem, randomIDSuffix(), ext)
+			name = path.Join(dir, fmt.Sprintf("%s-%s%s", stem, randomIDSuffix(), ext))
 		}
 		if _, taken := m.usedNames[name]; taken {
 			continue
 		}
-		if pathExists(filepath.Join(m.downloadDir, name)) {
+		if pathExists(m.finalPath(name)) || pathExists(m.stagingPath(name)) {
 			m.usedNames[name] = struct{}{}
 			// we reserve the existing name to avoid reuse and continue searching
 			continue
@@ -269,7 +1014,7 @@ func (m *Manager) uniqueFileName(base string) string {
 		return name
 	}
 	// Fallback: append timestamp-based suffix outside loop to guarantee exit
-	name := fmt.Sprintf("%s-%d%s", stem, time.Now().UnixNano(), ext)
+	name := path.Join(dir, fmt.Sprintf("%s-%d%s", stem, time.Now().UnixNano(), ext))
 	m.usedNames[name] = struct{}{}
 	return name
 }
@@ -291,6 +1036,15 @@ func randomIDSuffix() string {
 	return id
 }
 
+// fileSize returns the size of the file at path, or 0 if it cannot be stat'ed.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
 func pathExists(path string) bool {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -3,6 +3,7 @@ package downloader
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -24,12 +25,12 @@ func TestUniqueFileNameAddsSuffixOnCollision(t *testing.T) {
 	}
 	mgr := NewManager(st, tmp, 1)
 
-	first := mgr.uniqueFileName("file.txt")
+	first := mgr.uniqueFileName("", "file.txt")
 	if first != "file.txt" {
 		t.Fatalf("expected base name to be kept, got %q", first)
 	}
 
-	second := mgr.uniqueFileName("file.txt")
+	second := mgr.uniqueFileName("", "file.txt")
 	if second == first {
 		t.Fatalf("expected unique name, got duplicate %q", second)
 	}
@@ -51,7 +52,7 @@ func TestUniqueFileNameAvoidsExistingFileOnDisk(t *testing.T) {
 		t.Fatalf("write existing file: %v", err)
 	}
 
-	name := mgr.uniqueFileName("asset.bin")
+	name := mgr.uniqueFileName("", "asset.bin")
 	if name == "asset.bin" {
 		t.Fatalf("expected name to change because file exists on disk")
 	}
@@ -93,18 +94,46 @@ func TestRestoreFromStorageResetsTransientStates(t *testing.T) {
 		t.Fatalf("expected part to reset to pending, got %q", got.Parts[1].Status)
 	}
 
-	select {
-	case enqueued := <-mgr.jobCh:
-		if enqueued.ID != task.ID {
-			t.Fatalf("unexpected task enqueued: %q", enqueued.ID)
-		}
-	default:
-		t.Fatalf("expected task to be enqueued for processing")
+	queued := queuedJobs(mgr.sched)
+	if len(queued) != 1 || queued[0] != (job{taskID: task.ID, part: 1, host: "example.com"}) {
+		t.Fatalf("expected only the unfinished part to be enqueued, got %+v", queued)
 	}
 
 	mgr.Shutdown()
 }
 
+func TestRestoreKeepsTasksWithNothingToDownload(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	st.Put(&storage.Task{ID: "verified", Status: "partial", Parts: []storage.FilePart{
+		{URL: "https://example.com/a", FileName: "a.bin", Status: "done"},
+		{URL: "https://example.com/b", FileName: "b.bin", Status: "verify_failed"},
+	}})
+	// Stopped after the last part finished, before the task status was set.
+	st.Put(&storage.Task{ID: "stale", Status: "running", Parts: []storage.FilePart{
+		{URL: "https://example.com/c", FileName: "c.bin", Status: "done"},
+	}})
+
+	mgr := NewManager(st, tmp, 0)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	if got, _ := st.Get("verified"); got.Status != "partial" {
+		t.Fatalf("expected the task to stay partial, got %q", got.Status)
+	}
+	if got, _ := st.Get("stale"); got.Status != "done" {
+		t.Fatalf("expected the task to be settled as done, got %q", got.Status)
+	}
+	if queued := queuedJobs(mgr.sched); len(queued) != 0 {
+		t.Fatalf("expected nothing to be enqueued, got %+v", queued)
+	}
+}
+
 func TestManagerCreateTaskPersistsAndEnqueues(t *testing.T) {
 	tmp := t.TempDir()
 	st, err := storage.NewFileStorage(filepath.Join(tmp, "tasks.json"))
@@ -119,7 +148,7 @@ func TestManagerCreateTaskPersistsAndEnqueues(t *testing.T) {
 		"https://example.com/files/data.bin?version=2",
 	}
 
-	task, err := mgr.CreateTask(context.Background(), urls)
+	task, err := mgr.CreateTask(context.Background(), partSpecs(urls...), TaskOptions{})
 	if err != nil {
 		t.Fatalf("create task: %v", err)
 	}
@@ -149,13 +178,14 @@ func TestManagerCreateTaskPersistsAndEnqueues(t *testing.T) {
 		t.Fatalf("expected persisted status running, got %q", stored.Status)
 	}
 
-	select {
-	case enqueued := <-mgr.jobCh:
-		if enqueued.ID != task.ID {
-			t.Fatalf("unexpected task enqueued: %q", enqueued.ID)
+	queued := queuedJobs(mgr.sched)
+	if len(queued) != len(urls) {
+		t.Fatalf("expected %d parts to be enqueued, got %+v", len(urls), queued)
+	}
+	for i, j := range queued {
+		if j != (job{taskID: task.ID, part: i, host: "example.com"}) {
+			t.Fatalf("unexpected job enqueued: %+v", j)
 		}
-	default:
-		t.Fatalf("expected task to be enqueued")
 	}
 
 	mgr.Shutdown()
@@ -213,7 +243,7 @@ func TestManagerDownloadsFromHTTPServer(t *testing.T) {
 		t.Fatalf("restore: %v", err)
 	}
 
-	task, err := mgr.CreateTask(context.Background(), []string{srv.URL + "/file.bin"})
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/file.bin"), TaskOptions{})
 	if err != nil {
 		t.Fatalf("create task: %v", err)
 	}
@@ -365,3 +395,593 @@ func TestManagerResumeDownloadAfterRestart(t *testing.T) {
 		t.Fatalf("expected resume range header 'bytes=12-', got %q", rangeHeaders[len(rangeHeaders)-1])
 	}
 }
+
+// newRangeServer serves payload honoring "bytes=a-b" and "bytes=a-" ranges
+// and records every request as "METHOD Range".
+func newRangeServer(t *testing.T, payload []byte, acceptRanges bool) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.Header.Get("Range"))
+		mu.Unlock()
+
+		if acceptRanges {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		start, end := 0, len(payload)-1
+		rng := r.Header.Get("Range")
+		if rng != "" && acceptRanges {
+			bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+			start, _ = strconv.Atoi(bounds[0])
+			if len(bounds) == 2 && bounds[1] != "" {
+				end, _ = strconv.Atoi(bounds[1])
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(payload)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.WriteHeader(http.StatusOK)
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(payload[start : end+1])
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+// partSpecs turns plain URLs into CreateTask input.
+func partSpecs(urls ...string) []PartSpec {
+	specs := make([]PartSpec, len(urls))
+	for i, u := range urls {
+		specs[i] = PartSpec{URL: u}
+	}
+	return specs
+}
+
+func waitTaskStatus(t *testing.T, st *storage.FileStorage, id, status string) *storage.Task {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		stored, ok := st.Get(id)
+		if ok && stored.Status == status {
+			return stored
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s did not reach status %q in time", id, status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestManagerSegmentedDownload(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("0123456789", 100))
+	srv, requests := newRangeServer(t, payload, true)
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetSegments(4)
+	mgr.minSegmentSize = 100
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/seg.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+	mgr.Shutdown()
+
+	p := stored.Parts[0]
+	if len(p.Segments) != 4 {
+		t.Fatalf("expected 4 segments, got %d", len(p.Segments))
+	}
+	for i, seg := range p.Segments {
+		if !seg.Complete() {
+			t.Fatalf("segment %d not complete: %+v", i, seg)
+		}
+	}
+	if p.BytesDone != int64(len(payload)) || p.BytesTotal != int64(len(payload)) {
+		t.Fatalf("unexpected byte counters: done=%d total=%d", p.BytesDone, p.BytesTotal)
+	}
+	data, err := os.ReadFile(filepath.Join(tmp, p.FileName))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("unexpected file content")
+	}
+
+	ranged := 0
+	for _, r := range requests() {
+		if strings.HasPrefix(r, "GET bytes=") {
+			ranged++
+		}
+	}
+	if ranged != 4 {
+		t.Fatalf("expected 4 ranged GETs, got %v", requests())
+	}
+}
+
+func TestManagerSegmentedFallsBackWithoutAcceptRanges(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("abcdefghij", 100))
+	srv, requests := newRangeServer(t, payload, false)
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetSegments(4)
+	mgr.minSegmentSize = 100
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/plain.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+	mgr.Shutdown()
+
+	if len(stored.Parts[0].Segments) != 0 {
+		t.Fatalf("expected no segments, got %+v", stored.Parts[0].Segments)
+	}
+	got := requests()
+	if len(got) != 2 || got[0] != "HEAD " || got[1] != "GET " {
+		t.Fatalf("expected HEAD probe and a single plain GET, got %v", got)
+	}
+}
+
+func TestManagerResumesSegmentsIndependently(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("segment!", 50))
+	srv, requests := newRangeServer(t, payload, true)
+
+	// Two segments of 200 bytes, the first has 50 bytes, the second 120.
+	partial := make([]byte, len(payload))
+	copy(partial[:50], payload[:50])
+	copy(partial[200:320], payload[200:320])
+	if err := os.WriteFile(filepath.Join(tmp, "seg.bin"), partial[:320], 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "seg-task",
+		Status: "running",
+		Parts: []storage.FilePart{{
+			URL:        srv.URL + "/seg.bin",
+			FileName:   "seg.bin",
+			BytesTotal: int64(len(payload)),
+			BytesDone:  170,
+			Status:     "downloading",
+			Segments: []storage.Segment{
+				{Start: 0, End: 199, Done: 50},
+				{Start: 200, End: 399, Done: 120},
+			},
+		}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetSegments(2)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "seg-task", "done")
+	mgr.Shutdown()
+
+	if stored.Parts[0].BytesDone != int64(len(payload)) {
+		t.Fatalf("expected bytes done %d, got %d", len(payload), stored.Parts[0].BytesDone)
+	}
+	data, err := os.ReadFile(filepath.Join(tmp, "seg.bin"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("unexpected file content")
+	}
+
+	got := requests()
+	want := map[string]bool{"GET bytes=50-199": true, "GET bytes=320-399": true}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected requests: %v", got)
+	}
+	for _, r := range got {
+		if !want[r] {
+			t.Fatalf("unexpected request %q in %v", r, got)
+		}
+	}
+}
+
+func TestSegmentProgressIsCommittedAfterSync(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	st.Put(&storage.Task{ID: "t1", Status: "running", Parts: []storage.FilePart{{
+		FileName: "seg.bin", BytesTotal: 2000, Status: "downloading",
+		Segments: []storage.Segment{{Start: 0, End: 999}, {Start: 1000, End: 1999}},
+	}}})
+	mgr := NewManager(st, tmp, 0)
+	f, err := os.Create(filepath.Join(tmp, "seg.bin"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := mgr.copyBody(context.Background(), nil, f, pr, 1000,
+			func(n int64) { mgr.addProgress("t1", 0, n) },
+			func(n int64) { mgr.commitSegment("t1", 0, 1, n) })
+		done <- err
+	}()
+	for _, n := range []int{300, 1} {
+		if _, err := pw.Write(make([]byte, n)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	// copyBody reads the second write only after it has handled the first,
+	// and no checkpoint has synced anything yet.
+	task, _ := st.Get("t1")
+	if task.Parts[0].BytesDone < 300 || task.Parts[0].Segments[1].Done != 0 {
+		t.Fatalf("unsynced bytes must only show in bytes_done, got %+v", task.Parts[0])
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if task, _ := st.Get("t1"); task.Parts[0].Segments[1].Done != 301 {
+		t.Fatalf("expected the synced bytes to be committed on return, got %+v", task.Parts[0].Segments)
+	}
+}
+
+// newVersionedServer serves payload with a strong ETag and honors Range only
+// when If-Range is absent or matches the current ETag, like a real origin.
+func newVersionedServer(t *testing.T, payload []byte, etag string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var ifRanges []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		mu.Unlock()
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Accept-Ranges", "bytes")
+		rng := r.Header.Get("Range")
+		if ir := r.Header.Get("If-Range"); ir != "" && ir != etag {
+			rng = ""
+		}
+		if rng == "" || r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+			w.WriteHeader(http.StatusOK)
+			if r.Method != http.MethodHead {
+				_, _ = w.Write(payload)
+			}
+			return
+		}
+		bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+		start, _ := strconv.Atoi(bounds[0])
+		end := len(payload) - 1
+		if bounds[1] != "" {
+			end, _ = strconv.Atoi(bounds[1])
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(payload)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(payload[start : end+1])
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ifRanges...)
+	}
+}
+
+func TestManagerRestartsWhenRemoteChanged(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("new-version|", 20))
+	srv, ifRanges := newVersionedServer(t, payload, `"v2"`)
+
+	// 30 bytes of the previous version of the file are already on disk.
+	if err := os.WriteFile(filepath.Join(tmp, "doc.bin"), []byte(strings.Repeat("o", 30)), 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "changed",
+		Status: "running",
+		Parts: []storage.FilePart{{
+			URL:       srv.URL + "/doc.bin",
+			FileName:  "doc.bin",
+			BytesDone: 30,
+			Status:    "downloading",
+			ETag:      `"v1"`,
+		}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "changed", "done")
+	mgr.Shutdown()
+
+	data, err := os.ReadFile(filepath.Join(tmp, "doc.bin"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("expected file to be rewritten from zero, got %q", data)
+	}
+	if p := stored.Parts[0]; p.ETag != `"v2"` || p.BytesDone != int64(len(payload)) {
+		t.Fatalf("unexpected part state: %+v", p)
+	}
+	if got := ifRanges(); len(got) != 1 || got[0] != `"v1"` {
+		t.Fatalf("expected a single resume with If-Range \"v1\", got %v", got)
+	}
+}
+
+func TestManagerTruncatesWhenRangeIgnored(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("full-body.", 30))
+	srv, _ := newRangeServer(t, payload, false)
+
+	if err := os.WriteFile(filepath.Join(tmp, "full.bin"), payload[:40], 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "ignored",
+		Status: "running",
+		Parts: []storage.FilePart{{
+			URL:       srv.URL + "/full.bin",
+			FileName:  "full.bin",
+			BytesDone: 40,
+			Status:    "downloading",
+		}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "ignored", "done")
+	mgr.Shutdown()
+
+	data, err := os.ReadFile(filepath.Join(tmp, "full.bin"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("expected exact payload, got %d bytes", len(data))
+	}
+	if stored.Parts[0].BytesDone != int64(len(payload)) {
+		t.Fatalf("expected bytes done %d, got %d", len(payload), stored.Parts[0].BytesDone)
+	}
+}
+
+func TestManagerSegmentsRestartWhenRemoteChanged(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("0123456789", 40))
+	srv, _ := newVersionedServer(t, payload, `"v2"`)
+
+	if err := os.WriteFile(filepath.Join(tmp, "seg.bin"), []byte(strings.Repeat("x", 250)), 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "seg-changed",
+		Status: "running",
+		Parts: []storage.FilePart{{
+			URL:        srv.URL + "/seg.bin",
+			FileName:   "seg.bin",
+			BytesTotal: 400,
+			BytesDone:  100,
+			Status:     "downloading",
+			ETag:       `"v1"`,
+			Segments: []storage.Segment{
+				{Start: 0, End: 199, Done: 50},
+				{Start: 200, End: 399, Done: 50},
+			},
+		}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetSegments(2)
+	mgr.minSegmentSize = 100
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "seg-changed", "done")
+	mgr.Shutdown()
+
+	data, err := os.ReadFile(filepath.Join(tmp, "seg.bin"))
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("expected file to be rebuilt from the new version")
+	}
+	if p := stored.Parts[0]; p.ETag != `"v2"` || len(p.Segments) != 2 {
+		t.Fatalf("expected fresh segments with new validator: %+v", p)
+	}
+}
+
+func TestManagerDetectsTruncatedBody(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	payload := []byte(strings.Repeat("z", 100))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Announce the whole file but drop the connection after 40 bytes.
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(payload[:40])
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/cut.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "partial")
+	mgr.Shutdown()
+
+	p := stored.Parts[0]
+	if p.Status != "error" || !strings.Contains(p.Error, "incomplete") {
+		t.Fatalf("expected incomplete error, got status %q error %q", p.Status, p.Error)
+	}
+	if p.ErrorCode != "network" || !p.Retryable {
+		t.Fatalf("expected a retryable network error, got %q retryable=%v", p.ErrorCode, p.Retryable)
+	}
+	if p.BytesDone != 40 || p.BytesTotal != 100 {
+		t.Fatalf("unexpected counters: done=%d total=%d", p.BytesDone, p.BytesTotal)
+	}
+	if size := fileSize(filepath.Join(tmp, stagingName(p.FileName))); size != 40 {
+		t.Fatalf("expected partial data to be kept, file has %d bytes", size)
+	}
+}
+
+func TestManagerDetectsShortContentRange(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is complete on the wire, but the file is larger.
+		w.Header().Set("Content-Range", "bytes 10-19/50")
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	if err := os.WriteFile(filepath.Join(tmp, "cr.bin"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatalf("write partial: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "short",
+		Status: "running",
+		Parts:  []storage.FilePart{{URL: srv.URL + "/cr.bin", FileName: "cr.bin", Status: "pending"}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "short", "partial")
+	mgr.Shutdown()
+
+	p := stored.Parts[0]
+	if !strings.Contains(p.Error, "got 20 of 50 bytes") {
+		t.Fatalf("expected short read against Content-Range total, got %q", p.Error)
+	}
+	if p.BytesDone != 20 || p.BytesTotal != 50 {
+		t.Fatalf("unexpected counters: done=%d total=%d", p.BytesDone, p.BytesTotal)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	first, total, err := parseContentRange("bytes 100-199/1000")
+	if err != nil || first != 100 || total != 1000 {
+		t.Fatalf("unexpected parse result: %d %d %v", first, total, err)
+	}
+	if _, total, err := parseContentRange("bytes 0-9/*"); err != nil || total != -1 {
+		t.Fatalf("expected unknown total, got %d %v", total, err)
+	}
+	if _, _, err := parseContentRange("garbage"); err == nil {
+		t.Fatalf("expected error for malformed header")
+	}
+}
+
+func TestManagerDownloadsPartsOfOneTaskInParallel(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	// Every request waits until both parts are requested at the same time.
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	both := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(both)
+	}()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		select {
+		case <-both:
+			_, _ = w.Write([]byte("ok"))
+		case <-time.After(2 * time.Second):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 2)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/a", srv.URL+"/b"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitTaskStatus(t, st, task.ID, "done")
+	mgr.Shutdown()
+}
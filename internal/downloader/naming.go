@@ -0,0 +1,164 @@
+package downloader
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// safeFileName derives a provisional file name from the last path segment of
+// the URL, percent-decoded. The final name is settled once the response
+// headers are known, see resolveName.
+func safeFileName(u string) string {
+	var s string
+	if parsed, err := url.Parse(u); err == nil {
+		s = path.Base(parsed.EscapedPath())
+		if dec, err := url.PathUnescape(s); err == nil {
+			s = dec
+		}
+	}
+	s = cleanFileName(s)
+	if s == "" {
+		s = randomID()
+	}
+	return s
+}
+
+// cleanFileName turns a name suggested by a URL or a server into something
+// that is safe as a single path element. It returns "" if nothing usable
+// is left.
+func cleanFileName(name string) string {
+	// Servers sometimes send full paths, keep the last element only.
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	if isStagingName(name) {
+		// ".x.part" would be taken for a staging file and swept on restart.
+		name = strings.TrimLeft(name, ".")
+	}
+	return name
+}
+
+// dispositionName returns the file name from a Content-Disposition header.
+// filename* (RFC 5987) wins over filename; mime.ParseMediaType takes care of
+// both. Headers it rejects, such as unquoted UTF-8, fall back to a lenient
+// look at filename=.
+func dispositionName(v string) string {
+	if v == "" {
+		return ""
+	}
+	if _, params, err := mime.ParseMediaType(v); err == nil && params["filename"] != "" {
+		return cleanFileName(params["filename"])
+	}
+	for _, param := range strings.Split(v, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "filename") {
+			return cleanFileName(strings.Trim(strings.TrimSpace(val), `"`))
+		}
+	}
+	return ""
+}
+
+// preferredExtensions picks one extension for common types; the system
+// MIME table lists several for them in alphabetical order.
+var preferredExtensions = map[string]string{
+	"application/gzip":             ".gz",
+	"application/json":             ".json",
+	"application/msword":           ".doc",
+	"application/pdf":              ".pdf",
+	"application/vnd.ms-excel":     ".xls",
+	"application/vnd.rar":          ".rar",
+	"application/x-7z-compressed":  ".7z",
+	"application/x-bzip2":          ".bz2",
+	"application/x-gzip":           ".gz",
+	"application/x-iso9660-image":  ".iso",
+	"application/x-rar-compressed": ".rar",
+	"application/x-tar":            ".tar",
+	"application/x-xz":             ".xz",
+	"application/xml":              ".xml",
+	"application/zip":              ".zip",
+	"audio/mpeg":                   ".mp3",
+	"image/gif":                    ".gif",
+	"image/jpeg":                   ".jpg",
+	"image/png":                    ".png",
+	"image/svg+xml":                ".svg",
+	"image/webp":                   ".webp",
+	"text/csv":                     ".csv",
+	"text/html":                    ".html",
+	"text/plain":                   ".txt",
+	"text/xml":                     ".xml",
+	"video/mp4":                    ".mp4",
+}
+
+// mimeExtension returns the extension for a Content-Type, or "" when the
+// type says nothing about the format.
+func mimeExtension(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil || t == "application/octet-stream" {
+		return ""
+	}
+	if ext, ok := preferredExtensions[t]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(t); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// resolveName settles the name of a part from the response headers of its
+// first download: Content-Disposition replaces the name taken from the URL,
+// and a missing extension is added from Content-Type. The new name goes
+// through the same reservation as at creation, the file (if any) is renamed
+// and the name is persisted. It returns the staging path to write to.
+func (m *Manager) resolveName(taskID string, idx int, part storage.FilePart, h http.Header) (string, error) {
+	oldPath := m.stagingPath(part.FileName)
+	if part.NameFinal {
+		return oldPath, nil
+	}
+	dir, current := path.Split(part.FileName)
+	name := current
+	if cd := dispositionName(h.Get("Content-Disposition")); cd != "" {
+		name = cd
+	}
+	if path.Ext(name) == "" {
+		name += mimeExtension(h.Get("Content-Type"))
+	}
+
+	newName := part.FileName
+	if name != current {
+		newName = m.uniqueFileName(path.Clean(dir), name)
+		newPath := m.stagingPath(newName)
+		if err := os.Rename(oldPath, newPath); err != nil && !os.IsNotExist(err) {
+			m.releaseFileName(newName)
+			return "", err
+		}
+		m.releaseFileName(part.FileName)
+	}
+	m.updatePart(taskID, idx, func(p *storage.FilePart) {
+		p.FileName = newName
+		p.NameFinal = true
+	})
+	return m.stagingPath(newName), nil
+}
+
+func (m *Manager) releaseFileName(name string) {
+	m.mu.Lock()
+	delete(m.usedNames, name)
+	m.mu.Unlock()
+}
@@ -0,0 +1,125 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestSafeFileNameDecodesPath(t *testing.T) {
+	cases := map[string]string{
+		"https://example.com/files/report%202024.pdf?x=1": "report 2024.pdf",
+		"https://example.com/download?id=42":              "download",
+		"https://example.com/a/%2e%2e":                    "",
+		"https://example.com/dir/..%2F..%2Fetc%2Fpasswd":  "passwd",
+		"https://example.com/%E2%82%AC.txt":               "€.txt",
+		"https://example.com/.keep.part":                  "keep.part",
+	}
+	for in, want := range cases {
+		got := safeFileName(in)
+		if want == "" {
+			if len(got) != 16 {
+				t.Fatalf("safeFileName(%q) = %q, want a random name", in, got)
+			}
+			continue
+		}
+		if got != want {
+			t.Fatalf("safeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDispositionName(t *testing.T) {
+	cases := map[string]string{
+		`attachment; filename="plain.txt"; filename*=UTF-8''%E2%82%AC%20rates.pdf`: "€ rates.pdf",
+		`attachment; filename="a b.zip"`:                                           "a b.zip",
+		`attachment; filename=naïve.txt`:                                           "naïve.txt",
+		`attachment; filename="../../etc/passwd"`:                                  "passwd",
+		`attachment; filename="C:\\temp\\evil.exe"`:                                "evil.exe",
+		`attachment; filename=".b.iso.part"`:                                       "b.iso.part",
+		`inline`:                                                                   "",
+		``:                                                                         "",
+	}
+	for in, want := range cases {
+		if got := dispositionName(in); got != want {
+			t.Fatalf("dispositionName(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if ext := mimeExtension("image/jpeg"); ext != ".jpg" {
+		t.Fatalf("expected .jpg for image/jpeg, got %q", ext)
+	}
+	if ext := mimeExtension("application/octet-stream"); ext != "" {
+		t.Fatalf("octet-stream must not add an extension, got %q", ext)
+	}
+}
+
+func TestManagerNamesFileFromResponse(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''%E2%82%AC%20rates.pdf`)
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/get":
+			w.Header().Set("Content-Type", "application/zip")
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	// Somebody else's file already has the name the server suggests.
+	if err := os.WriteFile(filepath.Join(tmp, "€ rates.pdf"), []byte("keep"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), []PartSpec{
+		{URL: srv.URL + "/download?id=42"},
+		{URL: srv.URL + "/get"},
+		{URL: srv.URL + "/download?id=43", FileName: "mine"},
+	}, TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if task.Parts[0].FileName != "download" {
+		t.Fatalf("expected a provisional name from the URL, got %q", task.Parts[0].FileName)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+
+	pdf := stored.Parts[0].FileName
+	if !strings.HasPrefix(pdf, "€ rates-") || !strings.HasSuffix(pdf, ".pdf") || !stored.Parts[0].NameFinal {
+		t.Fatalf("expected a unique name derived from Content-Disposition, got %q", pdf)
+	}
+	if stored.Parts[1].FileName != "get.zip" {
+		t.Fatalf("expected an extension from Content-Type, got %q", stored.Parts[1].FileName)
+	}
+	if stored.Parts[2].FileName != "mine" {
+		t.Fatalf("an explicit file name must be kept, got %q", stored.Parts[2].FileName)
+	}
+	for _, name := range []string{pdf, "get.zip", "mine"} {
+		if !pathExists(filepath.Join(tmp, name)) {
+			t.Fatalf("expected %q on disk", name)
+		}
+	}
+	if pathExists(filepath.Join(tmp, "download")) {
+		t.Fatalf("provisional file left behind")
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmp, "€ rates.pdf")); string(data) != "keep" {
+		t.Fatalf("existing file was overwritten")
+	}
+}
@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// maxLimiterSleep bounds a single wait so rate changes take effect quickly.
+const maxLimiterSleep = 100 * time.Millisecond
+
+// limiter is a token bucket in bytes per second holding at most one second
+// worth of tokens. Readers take what they have read and go into debt, the
+// next reader waits until the debt is paid off. A zero rate means unlimited.
+// The rate can be changed while downloads are waiting on the limiter.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate int64) *limiter {
+	l := &limiter{}
+	l.setRate(rate)
+	return l
+}
+
+func (l *limiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rate < 0 {
+		rate = 0
+	}
+	l.refill(time.Now())
+	l.rate = float64(rate)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	if rate == 0 {
+		l.tokens = 0
+	}
+}
+
+func (l *limiter) limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// refill adds the tokens earned since the last call. Callers hold l.mu.
+func (l *limiter) refill(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+}
+
+// wait accounts for n bytes and blocks until the bucket is out of debt.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate > 0 {
+		l.refill(time.Now())
+		l.tokens -= float64(n)
+	}
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		l.refill(time.Now())
+		if l.tokens >= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+		d := time.Duration(-l.tokens / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		if d > maxLimiterSleep {
+			d = maxLimiterSleep
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// limiters is the chain a download has to pass: global first, then task.
+type limiters []*limiter
+
+func (ls limiters) wait(ctx context.Context, n int) error {
+	for _, l := range ls {
+		if err := l.wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetGlobalRateLimit changes the bandwidth cap shared by all workers, in
+// bytes per second. Zero removes the cap. Running downloads adapt at once.
+func (m *Manager) SetGlobalRateLimit(bps int64) {
+	m.globalLimit.setRate(bps)
+}
+
+func (m *Manager) GlobalRateLimit() int64 {
+	return m.globalLimit.limit()
+}
+
+// SetTaskRateLimit changes the bandwidth cap of a single task, in bytes per
+// second. Zero removes the cap. The value is persisted with the task.
+func (m *Manager) SetTaskRateLimit(id string, bps int64) (*storage.Task, error) {
+	if bps < 0 {
+		bps = 0
+	}
+	var prev string
+	found := m.storage.Update(id, func(t *storage.Task) {
+		prev = t.Status
+		if !finalStatus(t.Status) {
+			t.RateLimit = bps
+		}
+	})
+	if !found {
+		return nil, ErrTaskNotFound
+	}
+	if finalStatus(prev) {
+		return nil, ErrTaskFinished
+	}
+	_ = m.storage.Flush()
+
+	m.mu.Lock()
+	if l, ok := m.taskLimits[id]; ok {
+		l.setRate(bps)
+	}
+	m.mu.Unlock()
+
+	task, _ := m.storage.Get(id)
+	return task, nil
+}
+
+// limitersFor returns the limiter chain for a task, creating the task
+// limiter on first use so later rate changes reach running downloads.
+func (m *Manager) limitersFor(task *storage.Task) limiters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.taskLimits[task.ID]
+	if !ok {
+		l = newLimiter(task.RateLimit)
+		m.taskLimits[task.ID] = l
+	}
+	return limiters{m.globalLimit, l}
+}
+
+// dropLimiter forgets the limiter of a task that will not download again.
+func (m *Manager) dropLimiter(id string) {
+	m.mu.Lock()
+	delete(m.taskLimits, id)
+	m.mu.Unlock()
+}
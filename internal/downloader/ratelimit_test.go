@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestLimiterThrottlesToRate(t *testing.T) {
+	l := newLimiter(10000)
+	ctx := context.Background()
+
+	start := time.Now()
+	// The bucket starts empty: 2500 bytes at 10 kB/s take about 250ms.
+	for i := 0; i < 5; i++ {
+		if err := l.wait(ctx, 500); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("expected ~250ms of throttling, got %v", elapsed)
+	}
+}
+
+func TestLimiterUnlimitedAndRuntimeChange(t *testing.T) {
+	l := newLimiter(0)
+	start := time.Now()
+	if err := l.wait(context.Background(), 1<<30); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if time.Since(start) > 10*time.Millisecond {
+		t.Fatalf("unlimited limiter must not block")
+	}
+
+	// A waiter stuck on a tiny rate is released when the cap is lifted.
+	l.setRate(1)
+	done := make(chan error, 1)
+	go func() { done <- l.wait(context.Background(), 1000) }()
+	time.Sleep(20 * time.Millisecond)
+	l.setRate(0)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter was not released after lifting the limit")
+	}
+
+	l.setRate(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, 1000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+}
+
+func TestManagerAppliesTaskRateLimit(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	payload := make([]byte, 8000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(payload)
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	start := time.Now()
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/slow.bin"), TaskOptions{RateLimit: 20000})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("8000 bytes at 20 kB/s finished too fast: %v", elapsed)
+	}
+	if stored.RateLimit != 20000 {
+		t.Fatalf("expected rate limit to be persisted, got %d", stored.RateLimit)
+	}
+	if _, err := mgr.SetTaskRateLimit(task.ID, 0); !errors.Is(err, ErrTaskFinished) {
+		t.Fatalf("expected ErrTaskFinished for a finished task, got %v", err)
+	}
+
+	mgr.SetGlobalRateLimit(12345)
+	if got := mgr.GlobalRateLimit(); got != 12345 {
+		t.Fatalf("expected global limit 12345, got %d", got)
+	}
+}
@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed parts are retried. Delays grow as
+// BaseDelay * 2^(attempt-1), capped at MaxDelay, and are spread by
+// +/- Jitter (a fraction of the delay) so parts do not retry in lockstep.
+type RetryPolicy struct {
+	MaxAttempts int // total attempts per part including the first one
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		Jitter:      0.2,
+	}
+}
+
+// backoff returns the delay before the attempt following the given one.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// delay reports whether a part that failed its attempt-th try with err should
+// be retried and after how long. Retry-After from the server wins when it
+// asks for more patience than our own backoff.
+func (p RetryPolicy) delay(err error, attempt int) (time.Duration, bool) {
+	de := classify(err)
+	if attempt >= p.MaxAttempts || !de.Retryable {
+		return 0, false
+	}
+	d := p.backoff(attempt)
+	if de.RetryAfter > d {
+		d = de.RetryAfter
+	}
+	return d, true
+}
+
+// parseRetryAfter understands both delta-seconds and HTTP-date forms.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,179 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestRetryPolicyBackoffGrowsAndCaps(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w*time.Millisecond {
+			t.Fatalf("attempt %d: expected %v, got %v", i+1, w*time.Millisecond, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("jittered delay out of range: %v", d)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	if _, ok := p.delay(&Error{Code: CodeHTTPStatus, HTTPStatus: http.StatusNotFound, Err: errors.New("404")}, 1); ok {
+		t.Fatalf("404 must not be retried")
+	}
+	if _, ok := p.delay(errors.New("connection reset"), 3); ok {
+		t.Fatalf("attempts exhausted, must not retry")
+	}
+	d, ok := p.delay(&Error{Code: CodeHTTPStatus, HTTPStatus: http.StatusServiceUnavailable, Retryable: true, RetryAfter: 30 * time.Second, Err: errors.New("503")}, 1)
+	if !ok || d != 30*time.Second {
+		t.Fatalf("expected Retry-After to be honored, got %v %v", d, ok)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("120", now); d != 2*time.Minute {
+		t.Fatalf("expected 2m, got %v", d)
+	}
+	date := now.Add(90 * time.Second).Format(http.TimeFormat)
+	if d := parseRetryAfter(date, now); d != 90*time.Second {
+		t.Fatalf("expected 90s, got %v", d)
+	}
+	if d := parseRetryAfter("soon", now); d != 0 {
+		t.Fatalf("expected 0 for garbage, got %v", d)
+	}
+}
+
+func TestManagerRetriesTransientFailures(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("finally"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/flaky.txt"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+	mgr.Shutdown()
+
+	p := stored.Parts[0]
+	if p.Status != "done" || p.Attempts != 2 || p.NextRetryAt != 0 {
+		t.Fatalf("unexpected part state after retries: %+v", p)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 requests, got %d", calls.Load())
+	}
+}
+
+func TestManagerGivesUpOnPermanentFailure(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/missing"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "partial")
+	mgr.Shutdown()
+
+	if p := stored.Parts[0]; p.Status != "error" || p.Attempts != 1 {
+		t.Fatalf("expected a single failed attempt, got %+v", p)
+	}
+	if p := stored.Parts[0]; p.ErrorCode != "http_status" || p.HTTPStatus != http.StatusNotFound || p.Retryable {
+		t.Fatalf("expected a non-retryable http_status error, got %+v", p)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected no retries, got %d requests", calls.Load())
+	}
+}
+
+func TestManagerKeepsRetryScheduleAcrossRestart(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	var firstCall atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		firstCall.CompareAndSwap(0, time.Now().UnixNano())
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	retryAt := time.Now().Add(time.Second)
+	st.Put(&storage.Task{
+		ID:     "waiting",
+		Status: "running",
+		Parts: []storage.FilePart{{
+			URL:         srv.URL + "/later.txt",
+			FileName:    "later.txt",
+			Status:      "pending",
+			Attempts:    2,
+			NextRetryAt: retryAt.Unix(),
+		}},
+	})
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	stored := waitTaskStatus(t, st, "waiting", "done")
+	mgr.Shutdown()
+
+	if got := time.Unix(0, firstCall.Load()); got.Unix() < retryAt.Unix() {
+		t.Fatalf("part was retried at %v before its schedule %v", got, retryAt)
+	}
+	if stored.Parts[0].Attempts != 2 {
+		t.Fatalf("expected attempt count to survive restart, got %d", stored.Parts[0].Attempts)
+	}
+}
@@ -0,0 +1,443 @@
+package downloader
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Named task priorities. Any integer is accepted, higher runs first.
+const (
+	PriorityLow    = -10
+	PriorityNormal = 0
+	PriorityHigh   = 10
+)
+
+// ParsePriority accepts "low", "normal", "high" or an integer.
+func ParsePriority(v string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid priority %q: want low, normal, high or an integer", v)
+	}
+	return n, nil
+}
+
+// job is the unit of work: a single part of a task. host is derived from
+// the part URL and is only used for per-host limits.
+type job struct {
+	taskID string
+	part   int
+	host   string
+}
+
+// scheduler hands parts to workers. Ready parts are kept in a FIFO per task.
+// Higher priority tasks are always served first, tasks of the same priority
+// are served round-robin so a task with hundreds of parts cannot starve
+// small ones. Parts waiting for a retry sit in a timer heap until due.
+// A part whose host is at its connection limit or still inside its
+// politeness delay, or whose circuit breaker is open, is skipped in favour
+// of parts from other hosts. A part that is handed out stays in flight until
+// release, so it is never given to two workers at once.
+type scheduler struct {
+	mu       sync.Mutex
+	tasks    map[string]*taskQueue
+	rings    map[int][]string // tasks with ready parts per priority, next first
+	delayed  delayedJobs
+	queued   map[job]struct{}        // everything in tasks or delayed, for dedup
+	inFlight map[partKey]*delayedJob // handed out; non-nil if pushed again meanwhile
+	limits   hostLimits
+	hosts    map[string]*hostState
+	breaker  BreakerPolicy
+	breakers map[string]*breaker
+	wake     chan struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+type taskQueue struct {
+	priority int
+	parts    []job
+}
+
+// hostState counts the parts handed out for a host and when the next one
+// may start.
+type hostState struct {
+	active int
+	nextAt time.Time
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		tasks:    make(map[string]*taskQueue),
+		rings:    make(map[int][]string),
+		queued:   make(map[job]struct{}),
+		inFlight: make(map[partKey]*delayedJob),
+		hosts:    make(map[string]*hostState),
+		breakers: make(map[string]*breaker),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// push queues a part to be processed as soon as a worker is free.
+func (s *scheduler) push(j job, priority int) {
+	s.pushAt(j, priority, time.Time{})
+}
+
+// pushAt queues a part that must not start before at. A part that is in
+// flight is queued once its worker releases it.
+func (s *scheduler) pushAt(j job, priority int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	key := partKey{j.taskID, j.part}
+	if _, busy := s.inFlight[key]; busy {
+		s.inFlight[key] = &delayedJob{job: j, priority: priority, at: at}
+		return
+	}
+	s.queue(j, priority, at)
+}
+
+// queue adds j to the ready queues or the timer heap. Callers hold s.mu.
+func (s *scheduler) queue(j job, priority int, at time.Time) {
+	if _, dup := s.queued[j]; dup {
+		return
+	}
+	s.queued[j] = struct{}{}
+	if at.After(time.Now()) {
+		heap.Push(&s.delayed, delayedJob{job: j, priority: priority, at: at})
+	} else {
+		s.ready(j, priority)
+	}
+	s.signal()
+}
+
+// ready appends j to its task FIFO. Callers hold s.mu.
+func (s *scheduler) ready(j job, priority int) {
+	tq, ok := s.tasks[j.taskID]
+	if !ok {
+		tq = &taskQueue{priority: priority}
+		s.tasks[j.taskID] = tq
+		s.rings[priority] = append(s.rings[priority], j.taskID)
+	}
+	tq.parts = append(tq.parts, j)
+}
+
+// setPriority moves the queued parts of a task to another priority. Tasks
+// of the new priority that were already waiting stay ahead of it.
+func (s *scheduler) setPriority(taskID string, priority int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tq, ok := s.tasks[taskID]; ok && tq.priority != priority {
+		s.dropFromRing(taskID, tq.priority)
+		tq.priority = priority
+		s.rings[priority] = append(s.rings[priority], taskID)
+	}
+	for i := range s.delayed {
+		if s.delayed[i].taskID == taskID {
+			s.delayed[i].priority = priority
+		}
+	}
+}
+
+// dropFromRing removes the task from the ring of the given priority.
+// Callers hold s.mu.
+func (s *scheduler) dropFromRing(taskID string, priority int) {
+	ring := s.rings[priority]
+	for i, id := range ring {
+		if id == taskID {
+			ring = append(ring[:i], ring[i+1:]...)
+			break
+		}
+	}
+	if len(ring) == 0 {
+		delete(s.rings, priority)
+	} else {
+		s.rings[priority] = ring
+	}
+}
+
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// remove drops every queued part of the task, including those waiting for
+// an in-flight worker to let go.
+func (s *scheduler) remove(taskID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.inFlight {
+		if key.taskID == taskID {
+			s.inFlight[key] = nil
+		}
+	}
+	if tq, ok := s.tasks[taskID]; ok {
+		for _, j := range tq.parts {
+			delete(s.queued, j)
+		}
+		delete(s.tasks, taskID)
+		s.dropFromRing(taskID, tq.priority)
+	}
+	kept := s.delayed[:0]
+	for _, d := range s.delayed {
+		if d.taskID == taskID {
+			delete(s.queued, d.job)
+			continue
+		}
+		kept = append(kept, d)
+	}
+	s.delayed = kept
+	heap.Init(&s.delayed)
+}
+
+// next blocks until a part is ready or the scheduler is closed.
+func (s *scheduler) next() (job, bool) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return job{}, false
+		}
+		now := time.Now()
+		for len(s.delayed) > 0 && !s.delayed[0].at.After(now) {
+			d := heap.Pop(&s.delayed).(delayedJob)
+			s.ready(d.job, d.priority)
+		}
+		j, ok, retryAt := s.take(now)
+		if ok {
+			s.mu.Unlock()
+			return j, true
+		}
+		if len(s.delayed) > 0 && (retryAt.IsZero() || s.delayed[0].at.Before(retryAt)) {
+			retryAt = s.delayed[0].at
+		}
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !retryAt.IsZero() {
+			timer = time.NewTimer(retryAt.Sub(now))
+			due = timer.C
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-due:
+		case <-s.done:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// take pops the next part of the first task in the highest non-empty
+// priority ring and moves that task to the back of its ring. Parts of hosts
+// that are at their limit are passed over; when nothing can start, retryAt
+// is the earliest moment a politeness delay runs out (zero if only a
+// release can unblock the queue). Callers hold s.mu.
+func (s *scheduler) take(now time.Time) (j job, ok bool, retryAt time.Time) {
+	prios := make([]int, 0, len(s.rings))
+	for p := range s.rings {
+		prios = append(prios, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(prios)))
+
+	blocked := make(map[string]bool)
+	for _, prio := range prios {
+		ring := s.rings[prio]
+		for pos, id := range ring {
+			tq := s.tasks[id]
+			for i, cand := range tq.parts {
+				if blocked[cand.host] {
+					continue
+				}
+				if free, at := s.hostFree(cand.host, now); !free {
+					blocked[cand.host] = true
+					if !at.IsZero() && (retryAt.IsZero() || at.Before(retryAt)) {
+						retryAt = at
+					}
+					continue
+				}
+				s.pop(prio, pos, i)
+				s.acquire(cand.host, now)
+				s.inFlight[partKey{cand.taskID, cand.part}] = nil
+				// More work may be left for another idle worker.
+				if len(s.rings) > 0 {
+					s.signal()
+				}
+				return cand, true, time.Time{}
+			}
+		}
+	}
+	return job{}, false, retryAt
+}
+
+// pop removes part i of the task at ring position pos and moves the task to
+// the back of its ring, or drops it once it has nothing left.
+// Callers hold s.mu.
+func (s *scheduler) pop(prio, pos, i int) {
+	ring := s.rings[prio]
+	id := ring[pos]
+	tq := s.tasks[id]
+	j := tq.parts[i]
+	tq.parts = append(tq.parts[:i], tq.parts[i+1:]...)
+	ring = append(ring[:pos], ring[pos+1:]...)
+	if len(tq.parts) > 0 {
+		ring = append(ring, id)
+	} else {
+		delete(s.tasks, id)
+	}
+	if len(ring) == 0 {
+		delete(s.rings, prio)
+	} else {
+		s.rings[prio] = ring
+	}
+	delete(s.queued, j)
+}
+
+// hostFree reports whether one more part of host may start now. If the host
+// is only held back by its politeness delay or breaker cooldown, at is when
+// that ends. Callers hold s.mu.
+func (s *scheduler) hostFree(host string, now time.Time) (free bool, at time.Time) {
+	if host == "" {
+		return true, time.Time{}
+	}
+	if free, at := s.breakerFree(host, now); !free {
+		return false, at
+	}
+	hs, ok := s.hosts[host]
+	if !ok {
+		return true, time.Time{}
+	}
+	if l := s.limits.lookup(host); l.MaxConns > 0 && hs.active >= l.MaxConns {
+		return false, time.Time{}
+	}
+	if now.Before(hs.nextAt) {
+		return false, hs.nextAt
+	}
+	return true, time.Time{}
+}
+
+// acquire books a slot of host for a part that is being handed out.
+// Callers hold s.mu.
+func (s *scheduler) acquire(host string, now time.Time) {
+	if host == "" {
+		return
+	}
+	hs, ok := s.hosts[host]
+	if !ok {
+		hs = &hostState{}
+		s.hosts[host] = hs
+	}
+	hs.active++
+	if b, ok := s.breakers[host]; ok && b.state == breakerHalfOpen {
+		b.probing = true
+	}
+	if d := s.limits.lookup(host).Delay; d > 0 {
+		hs.nextAt = now.Add(d)
+	}
+}
+
+// release ends a job handed out by next once the worker is done with it: the
+// slot of its host is given back, and the part is queued if it was pushed
+// again in the meantime.
+func (s *scheduler) release(j job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := partKey{j.taskID, j.part}
+	again := s.inFlight[key]
+	delete(s.inFlight, key)
+	if again != nil && !s.closed {
+		s.queue(again.job, again.priority, again.at)
+	}
+	host := j.host
+	if host == "" {
+		return
+	}
+	if b, ok := s.breakers[host]; ok {
+		b.probing = false
+	}
+	hs, ok := s.hosts[host]
+	if !ok {
+		return
+	}
+	hs.active--
+	if hs.active <= 0 && !time.Now().Before(hs.nextAt) {
+		delete(s.hosts, host)
+	}
+	s.signal()
+}
+
+// hostLimit returns the limit that applies to host.
+func (s *scheduler) hostLimit(host string) HostLimit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits.lookup(host)
+}
+
+func (s *scheduler) setHostLimits(hl hostLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = hl
+	s.signal()
+}
+
+// len returns the number of queued parts, ready or delayed.
+func (s *scheduler) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queued)
+}
+
+// depth splits the queued parts into ready and delayed ones.
+func (s *scheduler) depth() (ready, delayed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queued) - len(s.delayed), len(s.delayed)
+}
+
+func (s *scheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+type delayedJob struct {
+	job
+	priority int
+	at       time.Time
+}
+
+// delayedJobs is a min-heap by due time.
+type delayedJobs []delayedJob
+
+func (h delayedJobs) Len() int           { return len(h) }
+func (h delayedJobs) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h delayedJobs) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *delayedJobs) Push(x any)        { *h = append(*h, x.(delayedJob)) }
+func (h *delayedJobs) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// queuedJobs drains the ready parts of s in the order workers would get them.
+func queuedJobs(s *scheduler) []job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []job
+	for {
+		j, ok, _ := s.take(time.Now())
+		if !ok {
+			return out
+		}
+		out = append(out, j)
+	}
+}
+
+func TestSchedulerRoundRobinAcrossTasks(t *testing.T) {
+	s := newScheduler()
+	for i := 0; i < 4; i++ {
+		s.push(job{taskID: "big", part: i}, PriorityNormal)
+	}
+	s.push(job{taskID: "small", part: 0}, PriorityNormal)
+	s.push(job{taskID: "tiny", part: 0}, PriorityNormal)
+
+	want := []job{
+		{taskID: "big", part: 0}, {taskID: "small", part: 0}, {taskID: "tiny", part: 0},
+		{taskID: "big", part: 1}, {taskID: "big", part: 2}, {taskID: "big", part: 3},
+	}
+	got := queuedJobs(s)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d jobs, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("position %d: expected %+v, got %+v (all: %+v)", i, want[i], got[i], got)
+		}
+	}
+}
+
+func TestSchedulerDeduplicatesAndRemoves(t *testing.T) {
+	s := newScheduler()
+	s.push(job{taskID: "a", part: 0}, PriorityNormal)
+	s.push(job{taskID: "a", part: 0}, PriorityNormal)
+	s.pushAt(job{taskID: "a", part: 1}, PriorityNormal, time.Now().Add(time.Hour))
+	s.push(job{taskID: "b", part: 0}, PriorityNormal)
+	if s.len() != 3 {
+		t.Fatalf("expected 3 queued parts, got %d", s.len())
+	}
+
+	s.remove("a")
+	if s.len() != 1 {
+		t.Fatalf("expected only task b to remain, got %d", s.len())
+	}
+	if got := queuedJobs(s); len(got) != 1 || got[0].taskID != "b" {
+		t.Fatalf("unexpected remaining jobs: %+v", got)
+	}
+}
+
+func TestSchedulerHoldsPartsInFlightUntilRelease(t *testing.T) {
+	s := newScheduler()
+	j := job{taskID: "a", part: 0, host: "one.example"}
+	s.push(j, PriorityNormal)
+	got, _ := s.next()
+
+	// Pause and Resume before the worker registered the part push it again.
+	s.push(j, PriorityNormal)
+	if again := queuedJobs(s); len(again) != 0 {
+		t.Fatalf("a part in flight was handed out twice: %+v", again)
+	}
+	s.release(got)
+	if again := queuedJobs(s); len(again) != 1 || again[0] != j {
+		t.Fatalf("expected the part to be queued on release, got %+v", again)
+	}
+
+	// A task removed meanwhile is not brought back by the release.
+	s.push(job{taskID: "b", part: 0}, PriorityNormal)
+	got, _ = s.next()
+	s.push(got, PriorityNormal)
+	s.remove("b")
+	s.release(got)
+	if s.len() != 0 {
+		t.Fatalf("expected the removed task to stay out of the queue, got %d", s.len())
+	}
+}
+
+func TestSchedulerReleasesDelayedJobs(t *testing.T) {
+	s := newScheduler()
+	s.pushAt(job{taskID: "a", part: 0}, PriorityNormal, time.Now().Add(50*time.Millisecond))
+
+	start := time.Now()
+	j, ok := s.next()
+	if !ok || j != (job{taskID: "a", part: 0}) {
+		t.Fatalf("unexpected job: %+v %v", j, ok)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Fatalf("delayed job released too early, after %v", waited)
+	}
+}
+
+func TestSchedulerCloseUnblocksWorkers(t *testing.T) {
+	s := newScheduler()
+	done := make(chan struct{})
+	go func() {
+		if _, ok := s.next(); ok {
+			t.Errorf("expected next to report closed scheduler")
+		}
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("next did not return after close")
+	}
+}
+
+func TestSchedulerServesHigherPriorityFirst(t *testing.T) {
+	s := newScheduler()
+	s.push(job{taskID: "low", part: 0}, PriorityLow)
+	s.push(job{taskID: "normal", part: 0}, PriorityNormal)
+	s.push(job{taskID: "normal", part: 1}, PriorityNormal)
+	s.push(job{taskID: "high", part: 0}, PriorityHigh)
+	s.pushAt(job{taskID: "high", part: 1}, PriorityHigh, time.Now().Add(time.Hour))
+
+	// Raising a queued task puts it behind tasks already waiting at that level.
+	s.setPriority("low", PriorityHigh)
+
+	want := []job{{taskID: "high", part: 0}, {taskID: "low", part: 0}, {taskID: "normal", part: 0}, {taskID: "normal", part: 1}}
+	got := queuedJobs(s)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d jobs, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("position %d: expected %+v, got %+v (all: %+v)", i, want[i], got[i], got)
+		}
+	}
+	if s.delayed[0].priority != PriorityHigh {
+		t.Fatalf("delayed job lost its priority: %+v", s.delayed[0])
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	cases := map[string]int{"low": PriorityLow, "HIGH": PriorityHigh, "": PriorityNormal, "42": 42, "-3": -3}
+	for in, want := range cases {
+		got, err := ParsePriority(in)
+		if err != nil || got != want {
+			t.Fatalf("ParsePriority(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Fatalf("expected error for unknown priority name")
+	}
+}
+
+func TestManagerRejectsTasksWhenQueueIsFull(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+
+	mgr := NewManager(st, tmp, 0)
+	mgr.SetQueueLimit(3)
+	defer mgr.Shutdown()
+
+	if _, err := mgr.CreateTask(context.Background(), partSpecs("https://example.com/a", "https://example.com/b"), TaskOptions{}); err != nil {
+		t.Fatalf("first task: %v", err)
+	}
+	_, err = mgr.CreateTask(context.Background(), partSpecs("https://example.com/c", "https://example.com/d"), TaskOptions{})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if n := len(st.List()); n != 1 {
+		t.Fatalf("rejected task must not be stored, have %d tasks", n)
+	}
+	if _, err := mgr.CreateTask(context.Background(), partSpecs("https://example.com/e"), TaskOptions{}); err != nil {
+		t.Fatalf("task fitting the limit: %v", err)
+	}
+	// Waiting would not help a task larger than the whole queue.
+	_, err = mgr.CreateTask(context.Background(), partSpecs("https://example.com/1", "https://example.com/2",
+		"https://example.com/3", "https://example.com/4"), TaskOptions{})
+	if !errors.Is(err, ErrTaskTooLarge) {
+		t.Fatalf("expected ErrTaskTooLarge, got %v", err)
+	}
+
+	stats := mgr.QueueStats()
+	if stats.Queued != 3 || stats.Delayed != 0 || stats.Active != 0 || stats.Limit != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSchedulerHostConnectionLimit(t *testing.T) {
+	s := newScheduler()
+	s.setHostLimits(hostLimits{def: HostLimit{MaxConns: 1}})
+	s.push(job{taskID: "a", part: 0, host: "one.example"}, PriorityHigh)
+	s.push(job{taskID: "a", part: 1, host: "one.example"}, PriorityHigh)
+	s.push(job{taskID: "b", part: 0, host: "two.example"}, PriorityNormal)
+
+	s.mu.Lock()
+	first, _, _ := s.take(time.Now())
+	// one.example is busy, so the lower priority part of another host goes.
+	second, ok, retryAt := s.take(time.Now())
+	_, blocked, _ := s.take(time.Now())
+	s.mu.Unlock()
+	if first != (job{taskID: "a", part: 0, host: "one.example"}) {
+		t.Fatalf("unexpected first job %+v", first)
+	}
+	if !ok || second.host != "two.example" {
+		t.Fatalf("expected a part of the other host, got %+v (ok=%v)", second, ok)
+	}
+	if blocked || !retryAt.IsZero() {
+		t.Fatalf("expected the queue to wait for a release")
+	}
+
+	s.release(first)
+	j, ok := s.next()
+	if !ok || j != (job{taskID: "a", part: 1, host: "one.example"}) {
+		t.Fatalf("expected the released host to be served, got %+v", j)
+	}
+}
+
+func TestSchedulerHostDelay(t *testing.T) {
+	s := newScheduler()
+	s.setHostLimits(hostLimits{overrides: map[string]HostLimit{"example.com": {Delay: 80 * time.Millisecond}}})
+	s.push(job{taskID: "a", part: 0, host: "www.example.com"}, PriorityNormal)
+	s.push(job{taskID: "a", part: 1, host: "www.example.com"}, PriorityNormal)
+
+	start := time.Now()
+	first, _ := s.next()
+	s.release(first)
+	second, ok := s.next()
+	if !ok || second.part != 1 {
+		t.Fatalf("unexpected second job %+v", second)
+	}
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Fatalf("second request to the host started after %v, want >= 80ms", elapsed)
+	}
+}
@@ -0,0 +1,161 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// PartSpec describes one file of a new task. Everything but URL is
+// optional:
+//   - FileName replaces the name derived from the URL, Subdir places the
+//     file in a subdirectory of the download directory;
+//   - Headers are sent with every request for the file;
+//   - Checksum (algo:hex, see ParseChecksum) and Size are what the finished
+//     file is verified against;
+//   - Mirrors are alternative URLs of the same file, tried in turn when an
+//     attempt fails.
+type PartSpec struct {
+	URL      string
+	FileName string
+	Subdir   string
+	Headers  map[string]string
+	Checksum string
+	Size     int64
+	Mirrors  []string
+}
+
+// FieldError is a validation problem with a single PartSpec field. Field
+// uses the JSON names of the API.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string { return e.Field + ": " + e.Message }
+
+func fieldError(field, format string, args ...any) error {
+	return &FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// managedHeaders are set by the downloader itself for resumes.
+var managedHeaders = map[string]bool{"Range": true, "If-Range": true}
+
+// Normalize validates the spec and brings it into its canonical form:
+// checksum lower-cased with its algorithm, header names canonicalized and
+// subdirectory cleaned. The returned error joins one *FieldError per
+// problem found.
+func (s *PartSpec) Normalize() error {
+	var errs []error
+	if err := checkURL(s.URL); err != nil {
+		errs = append(errs, fieldError("url", "%v", err))
+	}
+	for i, m := range s.Mirrors {
+		if err := checkURL(m); err != nil {
+			errs = append(errs, fieldError(fmt.Sprintf("mirrors[%d]", i), "%v", err))
+		}
+	}
+	if s.FileName != "" {
+		name := strings.TrimSpace(s.FileName)
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+			errs = append(errs, fieldError("file_name", "must be a plain file name without path separators"))
+		} else if isStagingName(name) {
+			errs = append(errs, fieldError("file_name", "must not look like a staging file (.name%s)", stagingSuffix))
+		}
+		s.FileName = name
+	}
+	if s.Subdir != "" {
+		dir := path.Clean(strings.ReplaceAll(strings.TrimSpace(s.Subdir), "\\", "/"))
+		if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") || strings.ContainsRune(dir, 0) {
+			errs = append(errs, fieldError("subdir", "must be a relative path inside the download directory"))
+		}
+		if dir == "." {
+			dir = ""
+		}
+		s.Subdir = dir
+	}
+	if len(s.Headers) > 0 {
+		headers := make(map[string]string, len(s.Headers))
+		for name, value := range s.Headers {
+			canon := http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch {
+			case !validHeaderName(canon):
+				errs = append(errs, fieldError("headers", "invalid header name %q", name))
+			case managedHeaders[canon]:
+				errs = append(errs, fieldError("headers", "%s is managed by the downloader", canon))
+			case strings.ContainsAny(value, "\r\n\x00"):
+				errs = append(errs, fieldError("headers", "invalid value for %s", canon))
+			}
+			headers[canon] = value
+		}
+		s.Headers = headers
+	}
+	if sum, err := ParseChecksum(s.Checksum); err != nil {
+		errs = append(errs, fieldError("checksum", "%v", err))
+	} else {
+		s.Checksum = sum
+	}
+	if s.Size < 0 {
+		errs = append(errs, fieldError("size", "must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func checkURL(raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return errors.New("required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("not a valid URL")
+	}
+	if u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r >= 0x7f || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceURL picks the URL an attempt downloads from: the primary URL first,
+// then the mirrors in turn as attempts fail.
+func sourceURL(p storage.FilePart) string {
+	if len(p.Mirrors) == 0 {
+		return p.URL
+	}
+	n := p.Attempts % (len(p.Mirrors) + 1)
+	if n == 0 {
+		return p.URL
+	}
+	return p.Mirrors[n-1]
+}
+
+// newRequest builds a request for the part URL carrying the headers given at
+// task creation.
+func newRequest(ctx context.Context, method string, part storage.FilePart) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, part.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range part.Headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestPartSpecNormalize(t *testing.T) {
+	spec := PartSpec{
+		URL:      "https://example.com/a.bin",
+		Subdir:   "./isos//2024/",
+		Headers:  map[string]string{"x-api-key": "secret"},
+		Checksum: "MD5:" + "0123456789ABCDEF0123456789ABCDEF",
+	}
+	if err := spec.Normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if spec.Subdir != "isos/2024" || spec.Headers["X-Api-Key"] != "secret" || spec.Checksum != "md5:0123456789abcdef0123456789abcdef" {
+		t.Fatalf("unexpected normalized spec %+v", spec)
+	}
+
+	bad := PartSpec{
+		URL:      "/relative",
+		FileName: "../etc/passwd",
+		Subdir:   "a/../../b",
+		Headers:  map[string]string{"Range": "bytes=0-", "X-Ok": "line\r\nbreak"},
+		Size:     -1,
+		Mirrors:  []string{"https://mirror.example.com/a.bin", ""},
+	}
+	err := bad.Normalize()
+	fields := map[string]int{}
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var fe *FieldError
+		if !errors.As(e, &fe) {
+			t.Fatalf("expected *FieldError, got %T", e)
+		}
+		fields[fe.Field]++
+	}
+	want := map[string]int{"url": 1, "file_name": 1, "subdir": 1, "headers": 2, "size": 1, "mirrors[1]": 1}
+	for f, n := range want {
+		if fields[f] != n {
+			t.Fatalf("expected %d errors for %s, got %v", n, f, fields)
+		}
+	}
+}
+
+func TestPartSpecRejectsStagingFileName(t *testing.T) {
+	spec := PartSpec{URL: "https://example.com/a.bin", FileName: ".keep.part"}
+	var fe *FieldError
+	if err := spec.Normalize(); !errors.As(err, &fe) || fe.Field != "file_name" {
+		t.Fatalf("expected a file_name error, got %v", err)
+	}
+}
+
+func TestSourceURLRotatesMirrors(t *testing.T) {
+	p := storage.FilePart{URL: "u", Mirrors: []string{"m1", "m2"}}
+	for attempts, want := range []string{"u", "m1", "m2", "u"} {
+		p.Attempts = attempts
+		if got := sourceURL(p); got != want {
+			t.Fatalf("attempt %d: expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestManagerPerURLOptions(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("from mirror"))
+	}))
+	defer mirror.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	mgr.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), []PartSpec{{
+		URL:      primary.URL + "/file",
+		FileName: "report.txt",
+		Subdir:   "docs/2024",
+		Headers:  map[string]string{"authorization": "Bearer t0k"},
+		Mirrors:  []string{mirror.URL + "/file"},
+	}}, TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	stored := waitTaskStatus(t, st, task.ID, "done")
+
+	p := stored.Parts[0]
+	if p.FileName != "docs/2024/report.txt" || p.Attempts != 1 {
+		t.Fatalf("unexpected part %+v", p)
+	}
+	data, err := os.ReadFile(filepath.Join(tmp, "docs", "2024", "report.txt"))
+	if err != nil || string(data) != "from mirror" {
+		t.Fatalf("expected the mirror copy in the subdirectory: %q, %v", data, err)
+	}
+
+	if _, err := mgr.CreateTask(context.Background(), []PartSpec{{URL: mirror.URL, Subdir: "/etc"}}, TaskOptions{}); err == nil {
+		t.Fatalf("expected an absolute subdir to be rejected")
+	}
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// Files are downloaded under a hidden staging name next to their final one,
+// so nobody watching the download directory sees them half written. Only a
+// complete, verified file is renamed to its real name.
+const stagingSuffix = ".part"
+
+// stagingName maps "dir/name.ext" to "dir/.name.ext.part".
+func stagingName(name string) string {
+	dir, base := path.Split(name)
+	return dir + "." + base + stagingSuffix
+}
+
+func isStagingName(base string) bool {
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, stagingSuffix) && len(base) > 1+len(stagingSuffix)
+}
+
+// stagingPath is where the part with this file name is written to.
+func (m *Manager) stagingPath(name string) string {
+	return filepath.Join(m.downloadDir, stagingName(name))
+}
+
+// finalPath is where the part with this file name ends up once done.
+func (m *Manager) finalPath(name string) string {
+	return filepath.Join(m.downloadDir, name)
+}
+
+// finalize moves a complete staging file to its real name. The file itself
+// is already synced by the download; syncing the directory makes the rename
+// durable too.
+func (m *Manager) finalize(name string) error {
+	dst := m.finalPath(name)
+	if err := os.Rename(m.stagingPath(name), dst); err != nil {
+		return &Error{Code: CodeDisk, Err: err}
+	}
+	if d, err := os.Open(filepath.Dir(dst)); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// adoptInPlace moves the partial file of an unfinished part that was written
+// under its final name by an older version into staging, so the download
+// resumes from it instead of starting over next to it.
+func (m *Manager) adoptInPlace(p storage.FilePart) {
+	if p.Status == "done" {
+		return
+	}
+	final, staging := m.finalPath(p.FileName), m.stagingPath(p.FileName)
+	if pathExists(staging) || !pathExists(final) {
+		return
+	}
+	_ = os.Rename(final, staging)
+}
+
+// sweepStaging removes staging files in the download directory that no
+// stored part owns any more, e.g. left behind by a task deleted from the
+// state file. owned holds the staging paths of unfinished parts and the
+// final paths of done ones. The quarantine directory is left alone.
+func (m *Manager) sweepStaging(owned map[string]bool) {
+	_ = filepath.WalkDir(m.downloadDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p == filepath.Clean(m.quarantineDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isStagingName(d.Name()) && !owned[p] {
+			_ = os.Remove(p)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestStagingName(t *testing.T) {
+	cases := map[string]string{
+		"a.bin":          ".a.bin.part",
+		"docs/2024/r.md": "docs/2024/.r.md.part",
+	}
+	for in, want := range cases {
+		if got := stagingName(in); got != want {
+			t.Fatalf("stagingName(%q) = %q, want %q", in, got, want)
+		}
+		if !isStagingName(filepath.Base(want)) {
+			t.Fatalf("%q not recognized as a staging name", want)
+		}
+	}
+	for _, name := range []string{"a.part", ".part", ".hidden", "x.bin"} {
+		if isStagingName(name) {
+			t.Fatalf("%q must not be taken for a staging file", name)
+		}
+	}
+}
+
+func TestDownloadIsStagedUntilComplete(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "8")
+		_, _ = w.Write([]byte("half"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/file.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitPartProgress(t, st, task.ID, 0)
+	final := filepath.Join(tmp, "file.bin")
+	if pathExists(final) {
+		t.Fatalf("half-written file visible under its final name")
+	}
+	if size := fileSize(filepath.Join(tmp, ".file.bin.part")); size != 4 {
+		t.Fatalf("expected 4 bytes in the staging file, got %d", size)
+	}
+
+	close(release)
+	waitTaskStatus(t, st, task.ID, "done")
+	if data, err := os.ReadFile(final); err != nil || string(data) != "halfdone" {
+		t.Fatalf("unexpected final file %q: %v", data, err)
+	}
+	if pathExists(filepath.Join(tmp, ".file.bin.part")) {
+		t.Fatalf("staging file left behind")
+	}
+}
+
+func TestRestoreSweepsOrphanedStagingFiles(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	st.Put(&storage.Task{
+		ID:     "parked",
+		Status: "paused",
+		Parts:  []storage.FilePart{{URL: "https://example.com/keep.bin", FileName: "sub/keep.bin", Status: "pending"}},
+	})
+	// Stored by an older version that allowed such names.
+	st.Put(&storage.Task{
+		ID:     "finished",
+		Status: "done",
+		Parts:  []storage.FilePart{{URL: "https://example.com/odd", FileName: ".odd.part", Status: "done"}},
+	})
+	files := map[string]bool{ // path -> expected to survive
+		"sub/.keep.bin.part":    true,
+		".odd.part":             true,
+		".orphan.bin.part":      false,
+		"sub/.gone.iso.part":    false,
+		"visible.bin":           true,
+		".quarantine/.odd.part": true,
+		"sub/.hidden":           true,
+	}
+	for name := range files {
+		p := filepath.Join(tmp, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	mgr := NewManager(st, tmp, 0)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	mgr.Shutdown()
+
+	for name, keep := range files {
+		if exists := pathExists(filepath.Join(tmp, name)); exists != keep {
+			t.Fatalf("%s: exists=%v, want %v", name, exists, keep)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// rateWeight is how much a new measurement moves the average speed, so a
+// single slow or fast chunk does not swing the ETA.
+const rateWeight = 0.3
+
+type partKey struct {
+	taskID string
+	idx    int
+}
+
+type partRate struct {
+	at    time.Time
+	bytes int64
+	speed float64
+}
+
+// rateMeter keeps a moving average of the transfer rate of every part that
+// is downloading.
+type rateMeter struct {
+	mu    sync.Mutex
+	parts map[partKey]*partRate
+}
+
+func newRateMeter() *rateMeter {
+	return &rateMeter{parts: make(map[partKey]*partRate)}
+}
+
+// sample records that the part has bytes done at now. At most once per
+// progressInterval it returns the updated average speed in bytes per second.
+func (r *rateMeter) sample(key partKey, bytes int64, now time.Time) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.parts[key]
+	if pr == nil {
+		r.parts[key] = &partRate{at: now, bytes: bytes}
+		return 0, false
+	}
+	elapsed := now.Sub(pr.at)
+	if elapsed < progressInterval {
+		return 0, false
+	}
+	rate := float64(bytes-pr.bytes) / elapsed.Seconds()
+	if pr.speed == 0 {
+		pr.speed = rate
+	} else {
+		pr.speed = (1-rateWeight)*pr.speed + rateWeight*rate
+	}
+	pr.at, pr.bytes = now, bytes
+	return pr.speed, true
+}
+
+func (r *rateMeter) forget(key partKey) {
+	r.mu.Lock()
+	delete(r.parts, key)
+	r.mu.Unlock()
+}
+
+// eta returns the seconds left for left bytes at speed, 0 when unknown.
+func eta(left int64, speed float64) int64 {
+	if left <= 0 || speed <= 0 {
+		return 0
+	}
+	return int64(float64(left)/speed + 0.5)
+}
+
+// sumRate sets the task speed to the sum of its parts. The ETA is only
+// given while the size of every unfinished part is known.
+func sumRate(t *storage.Task) {
+	t.Speed = 0
+	var left int64
+	known := true
+	for _, p := range t.Parts {
+		t.Speed += p.Speed
+		if partFinished(p.Status) {
+			continue
+		}
+		if p.BytesTotal <= 0 {
+			known = false
+		}
+		left += p.BytesTotal - p.BytesDone
+	}
+	t.ETA = 0
+	if known {
+		t.ETA = eta(left, t.Speed)
+	}
+}
+
+// trackTimes keeps the timing fields in step with the status changes made
+// since before: a part starts with its first download and finishes in a
+// final status, the task spans from its first part start to its own final
+// status. A part that is not downloading has no speed.
+func (m *Manager) trackTimes(t *storage.Task, before taskState, now time.Time) {
+	for i := range t.Parts {
+		p := &t.Parts[i]
+		if i < len(before.parts) && before.parts[i] == p.Status {
+			continue
+		}
+		if p.Status == "downloading" {
+			if p.StartedAt == 0 {
+				p.StartedAt = now.Unix()
+			}
+			p.FinishedAt, p.Duration = 0, 0
+			if t.StartedAt == 0 || p.StartedAt < t.StartedAt {
+				t.StartedAt = p.StartedAt
+			}
+			continue
+		}
+		m.rates.forget(partKey{t.ID, i})
+		p.Speed, p.ETA = 0, 0
+		if partFinished(p.Status) {
+			p.FinishedAt = now.Unix()
+			if p.StartedAt > 0 {
+				p.Duration = p.FinishedAt - p.StartedAt
+			}
+		}
+	}
+	if t.Status != before.status {
+		t.FinishedAt, t.Duration = 0, 0
+		if finalStatus(t.Status) {
+			t.FinishedAt = now.Unix()
+			if t.StartedAt > 0 {
+				t.Duration = t.FinishedAt - t.StartedAt
+			}
+		}
+	}
+	sumRate(t)
+}
+
+// clearRates drops speeds left over from before a restart.
+func clearRates(t *storage.Task) {
+	for i := range t.Parts {
+		t.Parts[i].Speed, t.Parts[i].ETA = 0, 0
+	}
+	t.Speed, t.ETA = 0, 0
+}
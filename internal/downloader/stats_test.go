@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestRateMeterThrottlesAndSmooths(t *testing.T) {
+	r := newRateMeter()
+	key := partKey{"t1", 0}
+	start := time.Now()
+	var got []float64
+	for i, at := range []time.Duration{0, 100 * time.Millisecond, time.Second, 1100 * time.Millisecond, 2 * time.Second} {
+		if speed, ok := r.sample(key, int64(i+1)*500, start.Add(at)); ok {
+			got = append(got, speed)
+		}
+	}
+	// 1000 B over the first second, then 1000 B over the next one.
+	if len(got) != 2 || got[0] != 1000 || got[1] != 1000 {
+		t.Fatalf("unexpected speeds %v", got)
+	}
+	if speed, _ := r.sample(key, 3000, start.Add(3*time.Second)); speed != 0.7*1000+0.3*500 {
+		t.Fatalf("expected moving average, got %v", speed)
+	}
+	r.forget(key)
+	if _, ok := r.sample(key, 4000, start.Add(4*time.Second)); ok {
+		t.Fatalf("a forgotten part starts measuring afresh")
+	}
+	if eta(1500, 1000) != 2 || eta(0, 1000) != 0 || eta(100, 0) != 0 {
+		t.Fatalf("unexpected eta")
+	}
+}
+
+func TestSumRate(t *testing.T) {
+	task := &storage.Task{Parts: []storage.FilePart{
+		{Status: "downloading", BytesTotal: 3000, BytesDone: 1000, Speed: 500},
+		{Status: "downloading", BytesTotal: 2000, BytesDone: 1000, Speed: 500},
+		{Status: "done", BytesTotal: 100, BytesDone: 100},
+	}}
+	sumRate(task)
+	if task.Speed != 1000 || task.ETA != 3 {
+		t.Fatalf("unexpected task rate %v, eta %d", task.Speed, task.ETA)
+	}
+	task.Parts = append(task.Parts, storage.FilePart{Status: "pending"})
+	if sumRate(task); task.ETA != 0 {
+		t.Fatalf("ETA is unknown while a part size is, got %d", task.ETA)
+	}
+}
+
+func TestManagerRecordsTimesAndRates(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	blocked := make(chan struct{})
+	var once sync.Once
+	release := func() { once.Do(func() { close(blocked) }) }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100000")
+		for i := 0; i < 4; i++ {
+			_, _ = w.Write(make([]byte, 1000))
+			w.(http.Flusher).Flush()
+			time.Sleep(progressInterval / 2)
+		}
+		<-blocked
+		_, _ = w.Write(make([]byte, 96000))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+	defer release()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(srv.URL+"/a.bin"), TaskOptions{})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		running, _ := st.Get(task.ID)
+		p := running.Parts[0]
+		if p.Speed > 0 {
+			if p.StartedAt == 0 || running.StartedAt != p.StartedAt || running.Speed != p.Speed || p.ETA <= 0 || p.FinishedAt != 0 {
+				t.Fatalf("unexpected running stats: task %+v", running)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no speed reported: %+v", running)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+
+	done := waitTaskStatus(t, st, task.ID, "done")
+	p := done.Parts[0]
+	if p.Speed != 0 || p.ETA != 0 || done.Speed != 0 {
+		t.Fatalf("finished parts have no speed: %+v", done)
+	}
+	if p.FinishedAt < p.StartedAt || p.Duration != p.FinishedAt-p.StartedAt || done.FinishedAt == 0 || done.Duration != done.FinishedAt-done.StartedAt {
+		t.Fatalf("unexpected times: %+v", done)
+	}
+}
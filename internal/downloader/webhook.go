@@ -0,0 +1,356 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+// webhookTimeout bounds a single delivery attempt.
+const webhookTimeout = 30 * time.Second
+
+// DefaultWebhookRetry is how long a receiver that is down is retried:
+// 10 attempts over roughly half an hour.
+func DefaultWebhookRetry() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 10,
+		BaseDelay:   5 * time.Second,
+		MaxDelay:    10 * time.Minute,
+		Jitter:      0.2,
+	}
+}
+
+// CheckCallbackURL validates a webhook URL given at task creation.
+func CheckCallbackURL(raw string) error {
+	if err := checkURL(raw); err != nil {
+		return err
+	}
+	if u, _ := url.Parse(raw); u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// WebhookPayload is the JSON body POSTed to the callback URL of a task once
+// it is done, partial or error.
+type WebhookPayload struct {
+	ID         string        `json:"id"` // the same across retries
+	Event      string        `json:"event"`
+	TaskID     string        `json:"task_id"`
+	Status     string        `json:"status"`
+	CreatedAt  int64         `json:"created_at"`
+	FinishedAt int64         `json:"finished_at"`
+	Files      []WebhookFile `json:"files"`
+}
+
+type WebhookFile struct {
+	URL       string `json:"url"`
+	FileName  string `json:"file_name"`
+	Status    string `json:"status"`
+	Size      int64  `json:"size"`
+	Checksum  string `json:"checksum,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// Sign returns the value of the X-Signature-256 header for body: the hex
+// HMAC-SHA256 of the raw body keyed with the task's secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type delivery struct {
+	ID       string          `json:"id"`
+	TaskID   string          `json:"task_id"`
+	URL      string          `json:"url"`
+	Secret   string          `json:"secret,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts,omitempty"`
+	NextAt   int64           `json:"next_at,omitempty"` // unix seconds
+}
+
+// outbox holds the webhook secrets of tasks that may still notify and the
+// deliveries still to be made. Secrets are kept here rather than in the
+// task, so they never show up in the API. With a path every change is
+// written to disk before it is acted on, so notifications survive restarts.
+type outbox struct {
+	mu      sync.Mutex
+	path    string
+	Secrets map[string]string `json:"secrets,omitempty"`
+	Pending []delivery        `json:"pending,omitempty"`
+	wake    chan struct{}
+}
+
+func newOutbox() *outbox {
+	return &outbox{Secrets: make(map[string]string), wake: make(chan struct{}, 1)}
+}
+
+// save writes the outbox atomically. Callers hold o.mu.
+func (o *outbox) save() error {
+	if o.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
+
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) setSecret(taskID, secret string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.Secrets[taskID] = secret
+	return o.save()
+}
+
+// dropSecret forgets the secret of a task that will not be notified.
+func (o *outbox) dropSecret(taskID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.Secrets[taskID]; ok {
+		delete(o.Secrets, taskID)
+		_ = o.save()
+	}
+}
+
+func (o *outbox) has(taskID string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, d := range o.Pending {
+		if d.TaskID == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the earliest delivery that is due, or how long to wait for
+// one (0 when there is nothing pending at all).
+func (o *outbox) next(now time.Time) (delivery, time.Duration, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var wait time.Duration
+	for _, d := range o.Pending {
+		at := time.Unix(d.NextAt, 0)
+		if !at.After(now) {
+			return d, 0, true
+		}
+		if w := at.Sub(now); wait == 0 || w < wait {
+			wait = w
+		}
+	}
+	return delivery{}, wait, false
+}
+
+// finish removes a delivery, or with retryAt > 0 reschedules it.
+func (o *outbox) finish(id string, retryAt int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.Pending {
+		if o.Pending[i].ID != id {
+			continue
+		}
+		if retryAt > 0 {
+			o.Pending[i].Attempts++
+			o.Pending[i].NextAt = retryAt
+		} else {
+			o.Pending = append(o.Pending[:i], o.Pending[i+1:]...)
+		}
+		break
+	}
+	return o.save()
+}
+
+// SetWebhookOutbox makes webhook deliveries durable in the file at path,
+// picking up what is left there from the previous run. Without it they
+// only live in memory. It must be called before RestoreFromStorage.
+func (m *Manager) SetWebhookOutbox(path string) error {
+	m.hooks.mu.Lock()
+	defer m.hooks.mu.Unlock()
+	m.hooks.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, m.hooks); err != nil {
+		return fmt.Errorf("webhook outbox %s: %w", path, err)
+	}
+	if m.hooks.Secrets == nil {
+		m.hooks.Secrets = make(map[string]string)
+	}
+	return nil
+}
+
+// SetWebhookRetry sets how failed webhook deliveries are retried.
+// It must be called before RestoreFromStorage starts delivering.
+func (m *Manager) SetWebhookRetry(p RetryPolicy) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	m.hookRetry = p
+}
+
+// queueWebhook puts the notification of a finished task into the outbox.
+func (m *Manager) queueWebhook(task *storage.Task) {
+	if task.Callback == "" || m.hooks.has(task.ID) {
+		return
+	}
+	payload := WebhookPayload{
+		ID:         randomID(),
+		Event:      "task.finished",
+		TaskID:     task.ID,
+		Status:     task.Status,
+		CreatedAt:  task.CreatedAt,
+		FinishedAt: time.Now().Unix(),
+		Files:      make([]WebhookFile, 0, len(task.Parts)),
+	}
+	for _, p := range task.Parts {
+		payload.Files = append(payload.Files, WebhookFile{
+			URL: p.URL, FileName: p.FileName, Status: p.Status, Size: p.BytesDone,
+			Checksum: p.Checksum, Error: p.Error, ErrorCode: p.ErrorCode,
+		})
+	}
+	body, _ := json.Marshal(payload)
+
+	o := m.hooks
+	o.mu.Lock()
+	o.Pending = append(o.Pending, delivery{
+		ID: payload.ID, TaskID: task.ID, URL: task.Callback,
+		Secret: o.Secrets[task.ID], Payload: body,
+	})
+	if task.Status == "done" {
+		// Partial and failed tasks run again after a restart and notify
+		// once more, so they keep their secret.
+		delete(o.Secrets, task.ID)
+	}
+	err := o.save()
+	o.mu.Unlock()
+
+	m.update(task.ID, func(t *storage.Task) {
+		t.CallbackStatus = "pending"
+		if err != nil {
+			t.CallbackError = err.Error()
+		}
+	})
+	o.notify()
+}
+
+// deliverWebhooks runs until Shutdown, sending due deliveries one by one.
+func (m *Manager) deliverWebhooks(ctx context.Context) {
+	defer m.wg.Done()
+	client := &http.Client{Timeout: webhookTimeout}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		d, wait, ok := m.hooks.next(time.Now())
+		if ok {
+			m.deliver(ctx, client, d)
+			continue
+		}
+		if wait == 0 {
+			wait = time.Hour
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.hooks.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (m *Manager) deliver(ctx context.Context, client *http.Client, d delivery) {
+	err := postWebhook(ctx, client, d)
+	if ctx.Err() != nil {
+		// Shutting down; the attempt does not count.
+		return
+	}
+	if err == nil {
+		_ = m.hooks.finish(d.ID, 0)
+		m.update(d.TaskID, func(t *storage.Task) {
+			t.CallbackStatus = "delivered"
+			t.CallbackError = ""
+		})
+		return
+	}
+	attempt := d.Attempts + 1
+	if attempt >= m.hookRetry.MaxAttempts {
+		_ = m.hooks.finish(d.ID, 0)
+		m.update(d.TaskID, func(t *storage.Task) {
+			t.CallbackStatus = "failed"
+			t.CallbackError = err.Error()
+		})
+		return
+	}
+	_ = m.hooks.finish(d.ID, time.Now().Add(m.hookRetry.backoff(attempt)).Unix())
+	m.update(d.TaskID, func(t *storage.Task) { t.CallbackError = err.Error() })
+}
+
+// postWebhook makes one delivery attempt. Any 2xx answer counts as received.
+func postWebhook(ctx context.Context, client *http.Client, d delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", d.ID)
+	req.Header.Set("X-Webhook-Event", "task.finished")
+	if d.Secret != "" {
+		req.Header.Set("X-Signature-256", Sign(d.Secret, d.Payload))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"test-task-30-09-2025/internal/storage"
+)
+
+func TestSign(t *testing.T) {
+	// Test vector 2 of RFC 4231.
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	if got != "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Fatalf("unexpected signature %s", got)
+	}
+}
+
+func TestCheckCallbackURL(t *testing.T) {
+	if err := CheckCallbackURL("https://hooks.example.com/done"); err != nil {
+		t.Fatalf("expected valid URL, got %v", err)
+	}
+	for _, bad := range []string{"", "/relative", "ftp://example.com/x"} {
+		if err := CheckCallbackURL(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+type hookRecorder struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	sigs     []string
+	ids      []string
+	failures atomic.Int32 // answer 500 while > 0
+}
+
+func (h *hookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	h.bodies = append(h.bodies, body)
+	h.sigs = append(h.sigs, r.Header.Get("X-Signature-256"))
+	h.ids = append(h.ids, r.Header.Get("X-Webhook-Id"))
+	h.mu.Unlock()
+	if h.failures.Add(-1) >= 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (h *hookRecorder) calls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.bodies)
+}
+
+func waitCallbackStatus(t *testing.T, st *storage.FileStorage, id, status string) *storage.Task {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if task, ok := st.Get(id); ok && task.CallbackStatus == status {
+			return task
+		}
+		if time.Now().After(deadline) {
+			task, _ := st.Get(id)
+			t.Fatalf("callback of %s did not reach %q: %+v", id, status, task)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagerDeliversSignedWebhookWithRetries(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer files.Close()
+	rec := &hookRecorder{}
+	rec.failures.Store(1)
+	hooks := httptest.NewServer(rec)
+	defer hooks.Close()
+
+	mgr := NewManager(st, tmp, 1)
+	outboxPath := filepath.Join(tmp, "state", "webhooks.json")
+	if err := mgr.SetWebhookOutbox(outboxPath); err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	mgr.SetWebhookRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	task, err := mgr.CreateTask(context.Background(), partSpecs(files.URL+"/a.bin"), TaskOptions{
+		Callback:       hooks.URL + "/done",
+		CallbackSecret: "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if data, _ := os.ReadFile(outboxPath); !strings.Contains(string(data), "s3cret") {
+		t.Fatalf("secret must be persisted in the outbox before the task finishes")
+	}
+	if stored, _ := st.Get(task.ID); strings.Contains(stored.Callback+stored.CallbackError, "s3cret") {
+		t.Fatalf("secret must not be stored with the task")
+	}
+
+	done := waitCallbackStatus(t, st, task.ID, "delivered")
+	if done.CallbackError != "" || rec.calls() != 2 {
+		t.Fatalf("expected delivery on the second attempt, got %d calls, task %+v", rec.calls(), done)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.ids[0] == "" || rec.ids[0] != rec.ids[1] || string(rec.bodies[0]) != string(rec.bodies[1]) {
+		t.Fatalf("retries must resend the same delivery, got ids %v", rec.ids)
+	}
+	if rec.sigs[1] != Sign("s3cret", rec.bodies[1]) {
+		t.Fatalf("bad signature %q", rec.sigs[1])
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(rec.bodies[1], &payload); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	if payload.TaskID != task.ID || payload.Status != "done" || len(payload.Files) != 1 || payload.Files[0].Checksum == "" {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+	if data, _ := os.ReadFile(outboxPath); strings.Contains(string(data), "s3cret") {
+		t.Fatalf("delivered notification must leave the outbox")
+	}
+}
+
+func TestManagerGivesUpOnWebhookAfterMaxAttempts(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	rec := &hookRecorder{}
+	rec.failures.Store(100)
+	hooks := httptest.NewServer(rec)
+	defer hooks.Close()
+
+	st.Put(&storage.Task{ID: "t1", Status: "partial", Callback: hooks.URL, Parts: []storage.FilePart{{URL: "https://example.com/a", Status: "error"}}})
+	mgr := NewManager(st, tmp, 0)
+	mgr.SetWebhookRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	if err := mgr.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer mgr.Shutdown()
+
+	failed := waitCallbackStatus(t, st, "t1", "failed")
+	if rec.calls() != 2 || !strings.Contains(failed.CallbackError, "500") {
+		t.Fatalf("expected 2 attempts and the last error, got %d calls, task %+v", rec.calls(), failed)
+	}
+}
+
+func TestManagerResumesWebhookOutboxAfterRestart(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	rec := &hookRecorder{}
+	hooks := httptest.NewServer(rec)
+	defer hooks.Close()
+	outboxPath := filepath.Join(tmp, "state", "webhooks.json")
+
+	// A previous run queued the notification but stopped before sending it.
+	st.Put(&storage.Task{ID: "t1", Status: "done", Callback: hooks.URL, CallbackStatus: "pending"})
+	first := NewManager(st, tmp, 0)
+	if err := first.SetWebhookOutbox(outboxPath); err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	first.hooks.Secrets["t1"] = "k"
+	task, _ := st.Get("t1")
+	task.CallbackStatus = ""
+	first.queueWebhook(task)
+
+	restarted := NewManager(st, tmp, 0)
+	if err := restarted.SetWebhookOutbox(outboxPath); err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	if err := restarted.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer restarted.Shutdown()
+
+	waitCallbackStatus(t, st, "t1", "delivered")
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.bodies) != 1 || rec.sigs[0] != Sign("k", rec.bodies[0]) {
+		t.Fatalf("expected one signed delivery, got %d, sig %v", len(rec.bodies), rec.sigs)
+	}
+}
+
+func TestManagerSignsWebhookOfTaskFinishedAgainAfterRestart(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := storage.NewFileStorage(filepath.Join(tmp, "state", "tasks.json"))
+	if err != nil {
+		t.Fatalf("storage init: %v", err)
+	}
+	var served atomic.Int32
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1) == 1 {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("payload"))
+	}))
+	defer files.Close()
+	rec := &hookRecorder{}
+	hooks := httptest.NewServer(rec)
+	defer hooks.Close()
+	outboxPath := filepath.Join(tmp, "state", "webhooks.json")
+
+	first := NewManager(st, tmp, 1)
+	if err := first.SetWebhookOutbox(outboxPath); err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	if err := first.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	task, err := first.CreateTask(context.Background(), partSpecs(files.URL+"/a.bin"), TaskOptions{
+		Callback:       hooks.URL,
+		CallbackSecret: "k",
+	})
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	waitTaskStatus(t, st, task.ID, "partial")
+	waitCallbackStatus(t, st, task.ID, "delivered")
+	first.Shutdown()
+
+	// The failed part is tried again and this time the task is done.
+	restarted := NewManager(st, tmp, 1)
+	if err := restarted.SetWebhookOutbox(outboxPath); err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	if err := restarted.RestoreFromStorage(); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	defer restarted.Shutdown()
+	waitTaskStatus(t, st, task.ID, "done")
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.calls() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a second notification, got %d", rec.calls())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for i, body := range rec.bodies {
+		if rec.sigs[i] != Sign("k", body) {
+			t.Fatalf("notification %d is not signed: %q", i, rec.sigs[i])
+		}
+	}
+	if data, _ := os.ReadFile(outboxPath); strings.Contains(string(data), `"k"`) {
+		t.Fatalf("the secret of a done task must leave the outbox")
+	}
+}
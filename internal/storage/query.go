@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// taskKey orders tasks by creation time, ties broken by ID.
+type taskKey struct {
+	created int64
+	id      string
+}
+
+func keyOf(t *Task) taskKey { return taskKey{t.CreatedAt, t.ID} }
+
+func (a taskKey) compare(b taskKey) int {
+	switch {
+	case a.created < b.created:
+		return -1
+	case a.created > b.created:
+		return 1
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// search returns the position of the first key in s.order not below k.
+// Callers hold s.mu.
+func (s *FileStorage) search(k taskKey) int {
+	return sort.Search(len(s.order), func(i int) bool { return s.order[i].compare(k) >= 0 })
+}
+
+// rebuildIndex builds the indexes from scratch after loading.
+func (s *FileStorage) rebuildIndex() {
+	s.order = make([]taskKey, 0, len(s.tasks))
+	s.byStatus = make(map[string]map[string]struct{})
+	for _, t := range s.tasks {
+		s.order = append(s.order, keyOf(t))
+		s.addStatus(t.ID, t.Status)
+	}
+	slices.SortFunc(s.order, taskKey.compare)
+}
+
+func (s *FileStorage) addStatus(id, status string) {
+	ids := s.byStatus[status]
+	if ids == nil {
+		ids = make(map[string]struct{})
+		s.byStatus[status] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// indexAdd adds a task that is not indexed yet. Callers hold s.mu.
+func (s *FileStorage) indexAdd(t *Task) {
+	k := keyOf(t)
+	i := s.search(k)
+	s.order = slices.Insert(s.order, i, k)
+	s.addStatus(t.ID, t.Status)
+}
+
+// indexDrop removes a task indexed under k and status. Callers hold s.mu.
+func (s *FileStorage) indexDrop(k taskKey, status string) {
+	if i := s.search(k); i < len(s.order) && s.order[i] == k {
+		s.order = slices.Delete(s.order, i, i+1)
+	}
+	delete(s.byStatus[status], k.id)
+}
+
+// Query selects a page of tasks. Zero fields do not filter.
+type Query struct {
+	Statuses []string
+	// CreatedFrom and CreatedTo bound created_at in unix seconds, inclusive.
+	CreatedFrom int64
+	CreatedTo   int64
+	// Search matches a substring of the URL of any part, case-insensitively,
+	// which covers host names too.
+	Search string
+	Desc   bool    // newest first
+	After  *Cursor // continue after the last task of the previous page
+	Limit  int     // 0 for all
+}
+
+// Cursor is the position of the last task of a page. It is handed to
+// clients as an opaque string.
+type Cursor struct {
+	Desc      bool
+	CreatedAt int64
+	ID        string
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+func (c Cursor) String() string {
+	dir := "a"
+	if c.Desc {
+		dir = "d"
+	}
+	raw := dir + ":" + strconv.FormatInt(c.CreatedAt, 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes what Cursor.String produced.
+func ParseCursor(v string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return Cursor{}, errBadCursor
+	}
+	dir, rest, _ := strings.Cut(string(raw), ":")
+	created, id, ok := strings.Cut(rest, ":")
+	if !ok || (dir != "a" && dir != "d") || id == "" {
+		return Cursor{}, errBadCursor
+	}
+	c := Cursor{Desc: dir == "d", ID: id}
+	if c.CreatedAt, err = strconv.ParseInt(created, 10, 64); err != nil {
+		return Cursor{}, errBadCursor
+	}
+	return c, nil
+}
+
+// Query returns the tasks matching q in created_at order, and the cursor of
+// the next page when there is one. Only the tasks of the page are copied:
+// the created_at range and the cursor are found by binary search in the
+// ordered index, and a status filter walks the status index instead when
+// that is shorter.
+func (s *FileStorage) Query(q Query) ([]*Task, *Cursor) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lo, hi := 0, len(s.order)
+	if q.CreatedFrom != 0 {
+		lo = s.search(taskKey{created: q.CreatedFrom})
+	}
+	if q.CreatedTo != 0 {
+		hi = s.search(taskKey{created: q.CreatedTo + 1})
+	}
+	if q.After != nil {
+		after := taskKey{q.After.CreatedAt, q.After.ID}
+		if q.Desc {
+			hi = min(hi, s.search(after))
+		} else {
+			// Past after itself; its ID sorts first among longer ones.
+			lo = max(lo, s.search(taskKey{after.created, after.id + "\x00"}))
+		}
+	}
+	if lo > hi {
+		lo = hi
+	}
+	keys := s.order[lo:hi]
+
+	statusOK := func(*Task) bool { return true }
+	if len(q.Statuses) > 0 {
+		n := 0
+		for _, st := range q.Statuses {
+			n += len(s.byStatus[st])
+		}
+		if n < len(keys) {
+			keys = s.statusKeys(q.Statuses, lo, hi)
+		} else {
+			statusOK = func(t *Task) bool { return slices.Contains(q.Statuses, t.Status) }
+		}
+	}
+	search := strings.ToLower(q.Search)
+
+	out := make([]*Task, 0, min(max(q.Limit, 0), len(keys)))
+	for j := range keys {
+		i := j
+		if q.Desc {
+			i = len(keys) - 1 - j
+		}
+		t := s.tasks[keys[i].id]
+		if !statusOK(t) || !matchSearch(t, search) {
+			continue
+		}
+		if q.Limit > 0 && len(out) == q.Limit {
+			last := out[len(out)-1]
+			return out, &Cursor{Desc: q.Desc, CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+		out = append(out, t.Clone())
+	}
+	return out, nil
+}
+
+// statusKeys returns the sorted keys of the tasks in statuses that fall
+// within s.order[lo:hi].
+func (s *FileStorage) statusKeys(statuses []string, lo, hi int) []taskKey {
+	if lo >= hi {
+		return nil
+	}
+	var keys []taskKey
+	for _, st := range statuses {
+		for id := range s.byStatus[st] {
+			k := keyOf(s.tasks[id])
+			if k.compare(s.order[lo]) < 0 || (hi < len(s.order) && k.compare(s.order[hi]) >= 0) {
+				continue
+			}
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, taskKey.compare)
+	return slices.Compact(keys)
+}
+
+func matchSearch(t *Task, search string) bool {
+	if search == "" {
+		return true
+	}
+	for _, p := range t.Parts {
+		if strings.Contains(strings.ToLower(p.URL), search) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func queryIDs(tasks []*Task) []string {
+	ids := make([]string, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func sameIDs(got []*Task, want ...string) bool {
+	ids := queryIDs(got)
+	if len(ids) != len(want) {
+		return false
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func queryFixture(t *testing.T) (*FileStorage, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	st, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("init storage: %v", err)
+	}
+	// t0..t5 created at 100, 100, 200, 300, 300, 400.
+	created := []int64{100, 100, 200, 300, 300, 400}
+	statuses := []string{"done", "running", "done", "error", "done", "running"}
+	for i := range created {
+		st.Put(&Task{
+			ID: fmt.Sprintf("t%d", i), CreatedAt: created[i], Status: statuses[i],
+			Parts: []FilePart{{URL: fmt.Sprintf("https://Host%d.example.com/f%d.bin", i%2, i)}},
+		})
+	}
+	return st, path
+}
+
+func TestQueryOrdersAndFilters(t *testing.T) {
+	st, _ := queryFixture(t)
+
+	if got, next := st.Query(Query{}); !sameIDs(got, "t0", "t1", "t2", "t3", "t4", "t5") || next != nil {
+		t.Fatalf("unexpected full listing %v, next %v", queryIDs(got), next)
+	}
+	if got, _ := st.Query(Query{Desc: true}); !sameIDs(got, "t5", "t4", "t3", "t2", "t1", "t0") {
+		t.Fatalf("unexpected newest first listing %v", queryIDs(got))
+	}
+	if got, _ := st.Query(Query{Statuses: []string{"running", "error"}}); !sameIDs(got, "t1", "t3", "t5") {
+		t.Fatalf("unexpected status filter %v", queryIDs(got))
+	}
+	if got, _ := st.Query(Query{Statuses: []string{"done"}, CreatedFrom: 200, CreatedTo: 300}); !sameIDs(got, "t2", "t4") {
+		t.Fatalf("unexpected status and range filter %v", queryIDs(got))
+	}
+	if got, _ := st.Query(Query{Search: "host1.EXAMPLE", Desc: true}); !sameIDs(got, "t5", "t3", "t1") {
+		t.Fatalf("unexpected search %v", queryIDs(got))
+	}
+	if got, _ := st.Query(Query{Statuses: []string{"paused"}}); got == nil || len(got) != 0 {
+		t.Fatalf("expected an empty, non-nil page, got %v", got)
+	}
+}
+
+func TestQueryPagesWithCursor(t *testing.T) {
+	st, _ := queryFixture(t)
+	for _, q := range []Query{
+		{Limit: 2},
+		{Limit: 2, Desc: true},
+		{Limit: 1, Statuses: []string{"done"}},
+		{Limit: 2, Statuses: []string{"done", "running", "error"}, Desc: true},
+	} {
+		want, _ := st.Query(Query{Desc: q.Desc, Statuses: q.Statuses})
+		var got []*Task
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("%+v: paging does not end", q)
+			}
+			page, next := st.Query(q)
+			got = append(got, page...)
+			if next == nil {
+				break
+			}
+			c, err := ParseCursor(next.String())
+			if err != nil || c != *next {
+				t.Fatalf("cursor does not round trip: %v %+v", err, c)
+			}
+			q.After = &c
+		}
+		if !sameIDs(got, queryIDs(want)...) {
+			t.Fatalf("%+v: pages give %v, want %v", q, queryIDs(got), queryIDs(want))
+		}
+	}
+	if _, err := ParseCursor("not a cursor"); err == nil {
+		t.Fatalf("expected invalid cursor")
+	}
+}
+
+func TestQueryIndexFollowsUpdatesAndReload(t *testing.T) {
+	st, path := queryFixture(t)
+	st.Update("t0", func(task *Task) { task.Status = "running" })
+	st.Put(&Task{ID: "t2", CreatedAt: 500, Status: "running"})
+	if err := st.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	want := []string{"t0", "t1", "t5", "t2"}
+	if got, _ := st.Query(Query{Statuses: []string{"running"}}); !sameIDs(got, want...) {
+		t.Fatalf("unexpected running tasks %v", queryIDs(got))
+	}
+	if got, _ := st.Query(Query{Statuses: []string{"done"}}); !sameIDs(got, "t4") {
+		t.Fatalf("unexpected done tasks %v", queryIDs(got))
+	}
+
+	reloaded, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("reload storage: %v", err)
+	}
+	if got, _ := reloaded.Query(Query{Statuses: []string{"running"}}); !sameIDs(got, want...) {
+		t.Fatalf("unexpected running tasks after reload %v", queryIDs(got))
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"os"
@@ -8,27 +9,126 @@ import (
 	"sync"
 )
 
+// Segment is a byte range of a FilePart fetched by its own connection.
+// End is inclusive, Done counts bytes already written from Start.
+type Segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+// Len returns the number of bytes covered by the segment.
+func (s Segment) Len() int64 { return s.End - s.Start + 1 }
+
+// Complete reports whether every byte of the segment has been written.
+func (s Segment) Complete() bool { return s.Done >= s.Len() }
+
 type FilePart struct {
-	URL        string `json:"url"`
-	FileName   string `json:"file_name"`
+	URL      string `json:"url"`
+	FileName string `json:"file_name"`
+	// NameFinal is set once FileName can no longer change: it was given
+	// explicitly or settled from the headers of the first response.
+	NameFinal  bool   `json:"name_final,omitempty"`
 	BytesTotal int64  `json:"bytes_total"`
 	BytesDone  int64  `json:"bytes_done"`
-	Status     string `json:"status"` // pending, downloading, done, error
+	Status     string `json:"status"` // pending, downloading, done, error, cancelled, verify_failed
 	Error      string `json:"error,omitempty"`
+	// ErrorCode classifies Error: network, http_status, validation, disk,
+	// cancelled or policy. Retryable tells whether another attempt may help.
+	ErrorCode  string    `json:"error_code,omitempty"`
+	Retryable  bool      `json:"retryable,omitempty"`
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Segments   []Segment `json:"segments,omitempty"`
+	// Validators of the remote file, used with If-Range on resume.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Attempts counts failed download attempts, NextRetryAt (unix seconds)
+	// is set while the part waits for its next one.
+	Attempts    int   `json:"attempts,omitempty"`
+	NextRetryAt int64 `json:"next_retry_at,omitempty"`
+	// What the finished file must look like, given at task creation. The
+	// checksum is written as algo:hex.
+	ExpectedSize     int64  `json:"expected_size,omitempty"`
+	ExpectedChecksum string `json:"expected_checksum,omitempty"`
+	// Checksum is the digest of the downloaded file, QuarantinePath is where
+	// it was moved to when it failed verification.
+	Checksum       string `json:"checksum,omitempty"`
+	QuarantinePath string `json:"quarantine_path,omitempty"`
+	// Extra request headers and alternative URLs of the same file.
+	Headers map[string]string `json:"headers,omitempty"`
+	Mirrors []string          `json:"mirrors,omitempty"`
+	// Speed is the moving average transfer rate in bytes per second and ETA
+	// the seconds left at that rate, both set while downloading. StartedAt
+	// and FinishedAt are unix seconds of the first download and of reaching
+	// a final status, Duration the seconds in between.
+	Speed      float64 `json:"speed,omitempty"`
+	ETA        int64   `json:"eta,omitempty"`
+	StartedAt  int64   `json:"started_at,omitempty"`
+	FinishedAt int64   `json:"finished_at,omitempty"`
+	Duration   int64   `json:"duration,omitempty"`
 }
 
 type Task struct {
 	ID        string     `json:"id"`
 	CreatedAt int64      `json:"created_at"`
-	Status    string     `json:"status"` // pending, running, done, error, partial
+	Status    string     `json:"status"`               // pending, running, paused, done, error, partial, cancelled
+	Priority  int        `json:"priority"`             // higher runs first
+	RateLimit int64      `json:"rate_limit,omitempty"` // bytes per second, 0 for unlimited
 	Parts     []FilePart `json:"parts"`
+	// Callback is POSTed to once the task finishes. CallbackStatus is
+	// pending, delivered or failed, CallbackError the last failure.
+	Callback       string `json:"callback,omitempty"`
+	CallbackStatus string `json:"callback_status,omitempty"`
+	CallbackError  string `json:"callback_error,omitempty"`
+	// Same as for FilePart; Speed sums the parts and StartedAt is the
+	// earliest part start.
+	Speed      float64 `json:"speed,omitempty"`
+	ETA        int64   `json:"eta,omitempty"`
+	StartedAt  int64   `json:"started_at,omitempty"`
+	FinishedAt int64   `json:"finished_at,omitempty"`
+	Duration   int64   `json:"duration,omitempty"`
+}
+
+// Clone returns a deep copy of the task that is safe to read or encode
+// while the original keeps being updated.
+func (t *Task) Clone() *Task {
+	c := *t
+	c.Parts = make([]FilePart, len(t.Parts))
+	for i, p := range t.Parts {
+		if p.Segments != nil {
+			p.Segments = append([]Segment(nil), p.Segments...)
+		}
+		if p.Headers != nil {
+			headers := make(map[string]string, len(p.Headers))
+			for k, v := range p.Headers {
+				headers[k] = v
+			}
+			p.Headers = headers
+		}
+		if p.Mirrors != nil {
+			p.Mirrors = append([]string(nil), p.Mirrors...)
+		}
+		c.Parts[i] = p
+	}
+	return &c
 }
 
+// FileStorage keeps tasks in memory and persists them as a single JSON file.
+// Tasks are owned by the storage: Put stores a copy, Get, List and Query
+// return copies, and in-place changes go through Update.
 type FileStorage struct {
-	mu    sync.RWMutex
-	path  string
-	tasks map[string]*Task
-	dirty bool
+	mu      sync.RWMutex
+	path    string
+	tasks   map[string]*Task
+	version uint64 // bumped on every change
+
+	// Indexes for Query, kept in memory only: every task ordered by
+	// created_at, and the IDs of the tasks in each status.
+	order    []taskKey
+	byStatus map[string]map[string]struct{}
+
+	flushMu sync.Mutex
+	flushed uint64 // version last written to disk
 }
 
 func NewFileStorage(path string) (*FileStorage, error) {
@@ -39,6 +139,7 @@ func NewFileStorage(path string) (*FileStorage, error) {
 	if err := fs.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
+	fs.rebuildIndex()
 	return fs, nil
 }
 
@@ -52,22 +153,36 @@ func (s *FileStorage) load() error {
 	return dec.Decode(&s.tasks)
 }
 
+// Flush writes the current state to disk if it changed since the last flush.
+// The snapshot is encoded under the read lock, the file is written without
+// holding it so updates are not blocked by disk I/O.
 func (s *FileStorage) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if !s.dirty {
+	version := s.version
+	if version == s.flushed {
+		s.mu.RUnlock()
 		return nil
 	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(s.tasks)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
 	tmp := s.path + ".tmp"
 	f, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(s.tasks); err != nil {
-		f.Close()
-		os.Remove(tmp)
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
 		return err
 	}
 	if err := f.Sync(); err != nil {
@@ -83,29 +198,57 @@ func (s *FileStorage) Flush() error {
 		_ = os.Remove(tmp)
 		return err
 	}
+	s.flushed = version
 	return nil
 }
 
 func (s *FileStorage) Save() error {
 	s.mu.Lock()
-	s.dirty = true
+	s.version++
 	s.mu.Unlock()
 	return s.Flush()
 }
 
 func (s *FileStorage) Put(task *Task) {
 	s.mu.Lock()
-	s.tasks[task.ID] = task
-	s.dirty = true
+	if old, ok := s.tasks[task.ID]; ok {
+		s.indexDrop(keyOf(old), old.Status)
+	}
+	c := task.Clone()
+	s.tasks[task.ID] = c
+	s.indexAdd(c)
+	s.version++
 	s.mu.Unlock()
 	_ = s.Flush()
 }
 
+// Update applies fn to the stored task under the write lock. The change is
+// kept in memory until the next Flush. It reports whether the task exists.
+func (s *FileStorage) Update(id string, fn func(t *Task)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return false
+	}
+	key, status := keyOf(t), t.Status
+	fn(t)
+	if keyOf(t) != key || t.Status != status {
+		s.indexDrop(key, status)
+		s.indexAdd(t)
+	}
+	s.version++
+	return true
+}
+
 func (s *FileStorage) Get(id string) (*Task, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	t, ok := s.tasks[id]
-	return t, ok
+	if !ok {
+		return nil, false
+	}
+	return t.Clone(), true
 }
 
 func (s *FileStorage) List() []*Task {
@@ -113,7 +256,7 @@ func (s *FileStorage) List() []*Task {
 	defer s.mu.RUnlock()
 	out := make([]*Task, 0, len(s.tasks))
 	for _, t := range s.tasks {
-		out = append(out, t)
+		out = append(out, t.Clone())
 	}
 	return out
 }
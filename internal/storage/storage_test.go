@@ -96,3 +96,46 @@ func TestFileStorageSavePersistsManualChange(t *testing.T) {
 		t.Fatalf("file does not contain saved task: %s", raw)
 	}
 }
+
+func TestFileStorageReturnsCopiesAndUpdatesInPlace(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "tasks.json")
+
+	st, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("init storage: %v", err)
+	}
+
+	st.Put(&Task{ID: "t", Parts: []FilePart{{
+		FileName: "a.bin",
+		Segments: []Segment{{Start: 0, End: 9}},
+	}}})
+
+	got, _ := st.Get("t")
+	got.Parts[0].Segments[0].Done = 5
+	got.Status = "mutated"
+
+	again, _ := st.Get("t")
+	if again.Status != "" || again.Parts[0].Segments[0].Done != 0 {
+		t.Fatalf("Get must return an independent copy: %+v", again)
+	}
+
+	if !st.Update("t", func(t *Task) { t.Parts[0].Segments[0].Done = 10 }) {
+		t.Fatalf("expected Update to find task")
+	}
+	if st.Update("missing", func(*Task) {}) {
+		t.Fatalf("expected Update to report missing task")
+	}
+	if err := st.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	st2, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("reload storage: %v", err)
+	}
+	reloaded, _ := st2.Get("t")
+	if !reloaded.Parts[0].Segments[0].Complete() {
+		t.Fatalf("expected updated segment to be persisted: %+v", reloaded.Parts[0].Segments)
+	}
+}
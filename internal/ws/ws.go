@@ -0,0 +1,280 @@
+// Package ws implements the server side of the WebSocket protocol (RFC 6455)
+// on top of net/http, enough for pushing JSON messages to browsers and
+// reading small messages back. Extensions and subprotocols are not
+// supported.
+package ws
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes of the frames a Conn deals with.
+const (
+	opContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes used by this server.
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+	CloseTryAgainLater = 1013
+)
+
+// MaxMessageSize bounds the messages ReadMessage accepts.
+const MaxMessageSize = 64 << 10
+
+// acceptGUID is appended to the client key, see RFC 6455 section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by ReadMessage once the peer closed the connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is an upgraded connection. Writes are safe for concurrent use, so a
+// reading goroutine may answer pings while another one pushes messages.
+type Conn struct {
+	nc  net.Conn
+	br  *bufio.Reader
+	wmu sync.Mutex
+}
+
+// Upgrade checks the handshake request and switches the connection to the
+// WebSocket protocol. On failure it has already answered the request.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: invalid key")
+	}
+
+	nc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	// The server's read and write timeouts do not apply to a long-lived
+	// connection.
+	_ = nc.SetDeadline(time.Time{})
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := brw.WriteString(resp); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &Conn{nc: nc, br: brw.Reader}, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText sends data as a single text message.
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(OpText, data)
+}
+
+// Ping sends a ping; the peer's pong is consumed by ReadMessage.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with code and reason and closes the connection
+// without waiting for the peer's answer.
+func (c *Conn) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	_ = c.nc.SetWriteDeadline(time.Now().Add(time.Second))
+	_ = c.writeFrame(opClose, payload)
+	return c.nc.Close()
+}
+
+// SetWriteDeadline bounds how long writes may block on a stalled peer.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.nc.SetWriteDeadline(t)
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	var hdr [10]byte
+	hdr[0] = 0x80 | op
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.nc.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := c.nc.Write(payload)
+	return err
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs skipped on the way. When the peer closes the connection, the
+// close is echoed and ErrClosed returned; on protocol errors the connection
+// is closed with the matching code.
+func (c *Conn) ReadMessage() (op int, data []byte, err error) {
+	op = -1
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			var pe protocolError
+			if errors.As(err, &pe) {
+				_ = c.Close(pe.code, pe.msg)
+			}
+			return 0, nil, err
+		}
+		switch frameOp {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			_ = c.Close(code, "")
+			return 0, nil, ErrClosed
+		case opContinuation:
+			if op < 0 {
+				_ = c.Close(CloseProtocolError, "unexpected continuation")
+				return 0, nil, errors.New("websocket: unexpected continuation frame")
+			}
+		case OpText, OpBinary:
+			if op >= 0 {
+				_ = c.Close(CloseProtocolError, "expected continuation")
+				return 0, nil, errors.New("websocket: interleaved message")
+			}
+			op = int(frameOp)
+		default:
+			_ = c.Close(CloseProtocolError, "unknown opcode")
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %d", frameOp)
+		}
+		if len(data)+len(payload) > MaxMessageSize {
+			_ = c.Close(CloseTooBig, "message too big")
+			return 0, nil, errors.New("websocket: message too big")
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+type protocolError struct {
+	code int
+	msg  string
+}
+
+func (e protocolError) Error() string { return "websocket: " + e.msg }
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0f
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, protocolError{CloseProtocolError, "reserved bits set"}
+	}
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, protocolError{CloseProtocolError, "client frames must be masked"}
+	}
+	size := uint64(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (size > 125 || !fin) {
+		return false, 0, nil, protocolError{CloseProtocolError, "invalid control frame"}
+	}
+	if size > MaxMessageSize {
+		return false, 0, nil, protocolError{CloseTooBig, "message too big"}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
@@ -0,0 +1,181 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient speaks just enough of the client side to drive a Conn.
+type testClient struct {
+	nc net.Conn
+	br *bufio.Reader
+}
+
+func dial(t *testing.T, srv *httptest.Server, header string) (*testClient, *http.Response) {
+	t.Helper()
+	nc, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET /ws HTTP/1.1\r\nHost: test\r\n" + header + "\r\n"
+	if _, err := nc.Write([]byte(req)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	return &testClient{nc: nc, br: br}, resp
+}
+
+const validHandshake = "Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n" +
+	"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+
+func (c *testClient) send(t *testing.T, fin bool, op byte, payload []byte) {
+	t.Helper()
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.nc.Write(frame); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+func (c *testClient) recv(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	if hdr[1]&0x80 != 0 {
+		t.Fatalf("server frames must not be masked")
+	}
+	size := int(hdr[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		_, _ = io.ReadFull(c.br, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatalf("recv payload: %v", err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := Upgrade(w, r); err == nil {
+			c.Close(CloseNormal, "")
+		}
+	}))
+	defer srv.Close()
+
+	cases := map[string]int{
+		"": http.StatusBadRequest,
+		strings.Replace(validHandshake, "13", "8", 1):                           http.StatusUpgradeRequired,
+		strings.Replace(validHandshake, "dGhlIHNhbXBsZSBub25jZQ==", "short", 1): http.StatusBadRequest,
+	}
+	for header, want := range cases {
+		if _, resp := dial(t, srv, header); resp.StatusCode != want {
+			t.Fatalf("handshake %q: expected %d, got %d", header, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestConnExchangesMessages(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_ = c.WriteText([]byte(strings.Repeat("x", 300)))
+		op, data, err := c.ReadMessage()
+		if err != nil || op != OpText {
+			got <- "error"
+			return
+		}
+		got <- string(data)
+		if _, _, err := c.ReadMessage(); !errors.Is(err, ErrClosed) {
+			got <- "expected close, got " + err.Error()
+		}
+	}))
+	defer srv.Close()
+
+	c, resp := dial(t, srv, validHandshake)
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	if op, data := c.recv(t); op != OpText || len(data) != 300 {
+		t.Fatalf("unexpected message op=%d len=%d", op, len(data))
+	}
+
+	// A fragmented message with a ping in between.
+	c.send(t, false, OpText, []byte("hel"))
+	c.send(t, true, opPing, []byte("p"))
+	if op, data := c.recv(t); op != opPong || string(data) != "p" {
+		t.Fatalf("expected pong, got op=%d %q", op, data)
+	}
+	c.send(t, true, opContinuation, []byte("lo"))
+	if msg := <-got; msg != "hello" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	c.send(t, true, opClose, []byte{0x03, 0xe8})
+	if op, data := c.recv(t); op != opClose || binary.BigEndian.Uint16(data) != CloseNormal {
+		t.Fatalf("expected close echo, got op=%d %v", op, data)
+	}
+	select {
+	case msg := <-got:
+		t.Fatal(msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConnRejectsUnmaskedFrames(t *testing.T) {
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_, _, err = c.ReadMessage()
+		done <- err
+	}))
+	defer srv.Close()
+
+	c, _ := dial(t, srv, validHandshake)
+	_, _ = c.nc.Write([]byte{0x81, 0x01, 'x'})
+	if op, data := c.recv(t); op != opClose || binary.BigEndian.Uint16(data) != CloseProtocolError {
+		t.Fatalf("expected protocol error close, got op=%d %v", op, data)
+	}
+	if err := <-done; err == nil {
+		t.Fatalf("expected read error")
+	}
+}